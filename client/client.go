@@ -0,0 +1,308 @@
+// Package client implements a client for the Monban REST API.
+//
+// A Client logs in once and then keeps its tokens fresh: access tokens are
+// refreshed shortly before they expire and only one refresh is in flight at a
+// time no matter how many goroutines use the client. This matters because
+// Monban rotates refresh tokens and treats a refresh token that is presented
+// twice as stolen.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+// ErrNoGrant is returned when tokens are needed before logging in.
+var ErrNoGrant = errors.New("client: not logged in")
+
+// DefaultRefreshBefore is how long before the access token expires it gets
+// refreshed when Client.RefreshBefore is zero.
+const DefaultRefreshBefore = 30 * time.Second
+
+// Error is an error returned by the Monban API.
+type Error struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+	// Type is a machine readable code such as "token_expired".
+	Type string `json:"error"`
+}
+
+func (e *Error) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("monban: %d %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("monban: %d %s (%s)", e.Code, e.Message, e.Type)
+}
+
+// Grant holds the tokens of a logged in client.
+type Grant struct {
+	AccessToken  string
+	RefreshToken string
+	// Expiry is the time the access token expires.
+	Expiry time.Time
+}
+
+// Client talks to a Monban server. It is safe for concurrent use.
+type Client struct {
+	// BaseURL is the URL of the Monban server, for example
+	// "https://auth.example.com".
+	BaseURL string
+	// HTTPClient is used for the requests to Monban. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+	// Audience lists the services the access tokens are requested for.
+	Audience []string
+	// Scope lists the scopes the access tokens are requested for. If empty,
+	// all the scopes the user is allowed are requested.
+	Scope []string
+	// RefreshBefore is how long before the access token expires it gets
+	// refreshed. If zero, DefaultRefreshBefore is used.
+	RefreshBefore time.Duration
+
+	mu         sync.Mutex
+	grant      *Grant
+	refreshing *refreshCall
+}
+
+// refreshCall is a refresh in flight. Its error is set before done is closed.
+type refreshCall struct {
+	done chan struct{}
+	err  error
+}
+
+// New returns a client for the Monban server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+type loginReq struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Audience []string `json:"audience,omitempty"`
+	Scope    []string `json:"scope,omitempty"`
+}
+
+type loginResp struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type refreshReq struct {
+	RefreshToken string   `json:"refresh_token"`
+	Audience     []string `json:"audience,omitempty"`
+	Scope        []string `json:"scope,omitempty"`
+}
+
+type refreshResp struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type logoutReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Login authenticates with username and password and stores the grant.
+func (c *Client) Login(username, password string) error {
+	req := &loginReq{Username: username, Password: password, Audience: c.Audience, Scope: c.Scope}
+	resp := new(loginResp)
+	if err := c.post("/login", req, resp); err != nil {
+		return err
+	}
+	g, err := newGrant(resp.AccessToken, resp.RefreshToken)
+	if err != nil {
+		return err
+	}
+	c.SetGrant(g)
+	return nil
+}
+
+// Logout revokes the refresh token on the server and forgets the grant.
+func (c *Client) Logout() error {
+	c.mu.Lock()
+	g := c.grant
+	c.grant = nil
+	c.mu.Unlock()
+	if g == nil {
+		return ErrNoGrant
+	}
+	return c.post("/logout", &logoutReq{RefreshToken: g.RefreshToken}, nil)
+}
+
+// Grant returns the current grant or nil if the client is not logged in. It
+// can be stored and restored later with SetGrant.
+func (c *Client) Grant() *Grant {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.grant == nil {
+		return nil
+	}
+	g := *c.grant
+	return &g
+}
+
+// SetGrant replaces the grant of the client.
+func (c *Client) SetGrant(g *Grant) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.grant = g
+}
+
+// Token returns a valid access token, refreshing the grant first if the
+// access token is about to expire.
+func (c *Client) Token() (string, error) {
+	c.mu.Lock()
+	g := c.grant
+	c.mu.Unlock()
+	if g == nil {
+		return "", ErrNoGrant
+	}
+	if time.Until(g.Expiry) > c.refreshBefore() {
+		return g.AccessToken, nil
+	}
+	if err := c.refresh(g.AccessToken); err != nil {
+		return "", err
+	}
+	return c.accessToken()
+}
+
+// Refresh exchanges the refresh token for new tokens.
+func (c *Client) Refresh() error {
+	at, err := c.accessToken()
+	if err != nil {
+		return err
+	}
+	return c.refresh(at)
+}
+
+func (c *Client) accessToken() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.grant == nil {
+		return "", ErrNoGrant
+	}
+	return c.grant.AccessToken, nil
+}
+
+func (c *Client) refreshBefore() time.Duration {
+	if c.RefreshBefore == 0 {
+		return DefaultRefreshBefore
+	}
+	return c.RefreshBefore
+}
+
+// refresh refreshes the grant unless the stale access token has already been
+// replaced. Concurrent callers wait for the refresh in flight instead of
+// starting their own.
+func (c *Client) refresh(stale string) error {
+	c.mu.Lock()
+	if c.grant == nil {
+		c.mu.Unlock()
+		return ErrNoGrant
+	}
+	if c.grant.AccessToken != stale {
+		// Another goroutine has already refreshed the grant.
+		c.mu.Unlock()
+		return nil
+	}
+	if call := c.refreshing; call != nil {
+		c.mu.Unlock()
+		<-call.done
+		return call.err
+	}
+	call := &refreshCall{done: make(chan struct{})}
+	c.refreshing = call
+	cur := c.grant
+	c.mu.Unlock()
+
+	g, err := c.doRefresh(cur.RefreshToken)
+
+	c.mu.Lock()
+	switch {
+	case err != nil:
+	case c.grant == nil:
+		// Logout cleared the grant while refreshing so the refreshed grant
+		// must not bring it back.
+		err = ErrNoGrant
+	case c.grant != cur:
+		// SetGrant replaced the grant while refreshing and takes
+		// precedence.
+	default:
+		c.grant = g
+	}
+	call.err = err
+	c.refreshing = nil
+	c.mu.Unlock()
+	close(call.done)
+	return err
+}
+
+func (c *Client) doRefresh(refreshToken string) (*Grant, error) {
+	req := &refreshReq{RefreshToken: refreshToken, Audience: c.Audience, Scope: c.Scope}
+	resp := new(refreshResp)
+	if err := c.post("/refresh", req, resp); err != nil {
+		return nil, err
+	}
+	return newGrant(resp.AccessToken, resp.RefreshToken)
+}
+
+func newGrant(accessToken, refreshToken string) (*Grant, error) {
+	tok, err := jwt.DecodeUnverified(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("client: decode access token: %v", err)
+	}
+	return &Grant{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		Expiry:       time.Unix(tok.ExpiresAt, 0),
+	}, nil
+}
+
+// post sends in as JSON to the API path and decodes the response to out
+// unless out is nil.
+func (c *Client) post(path string, in, out interface{}) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+	url := strings.TrimSuffix(c.BaseURL, "/") + path
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		e := &Error{Code: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return e
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("client: decode %s response: %v", path, err)
+	}
+	return nil
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient == nil {
+		return http.DefaultClient
+	}
+	return c.HTTPClient
+}
@@ -0,0 +1,209 @@
+package client_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/client"
+	"github.com/kusubooru/monban/jwt"
+)
+
+var secret = []byte("secret")
+
+// fakeMonban is a fake Monban server that counts refreshes.
+type fakeMonban struct {
+	t         *testing.T
+	refreshes int32
+	accessDur time.Duration
+}
+
+func (f *fakeMonban) token(sub string, dur time.Duration) string {
+	now := time.Now()
+	s, err := jwt.Encode(&jwt.Token{Subject: sub, IssuedAt: now.Unix(), ExpiresAt: now.Add(dur).Unix()}, secret)
+	if err != nil {
+		f.t.Fatal("jwt.Encode failed:", err)
+	}
+	return s
+}
+
+func (f *fakeMonban) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/login":
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token":  f.token("1", f.accessDur),
+			"refresh_token": f.token("1", time.Hour),
+		})
+	case "/refresh":
+		atomic.AddInt32(&f.refreshes, 1)
+		// Give concurrent callers the chance to pile up.
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token":  f.token("1", time.Hour),
+			"refresh_token": f.token("1", time.Hour),
+		})
+	case "/logout":
+	case "/api":
+		tok, _, err := jwt.Decode(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), secret)
+		if err != nil || time.Until(time.Unix(tok.ExpiresAt, 0)) < time.Minute {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestClient_Token_singleRefresh(t *testing.T) {
+	f := &fakeMonban{t: t, accessDur: 10 * time.Second}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	if err := c.Login("foo", "bar"); err != nil {
+		t.Fatal("Login failed:", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.Token(); err != nil {
+				t.Error("Token failed:", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if got, want := atomic.LoadInt32(&f.refreshes), int32(1); got != want {
+		t.Errorf("server got %d refreshes, want %d", got, want)
+	}
+}
+
+func TestClient_Logout_duringRefresh(t *testing.T) {
+	f := &fakeMonban{t: t, accessDur: 10 * time.Second}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	if err := c.Login("foo", "bar"); err != nil {
+		t.Fatal("Login failed:", err)
+	}
+
+	errc := make(chan error)
+	go func() {
+		_, err := c.Token()
+		errc <- err
+	}()
+	// Log out while the refresh is in flight.
+	for atomic.LoadInt32(&f.refreshes) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := c.Logout(); err != nil {
+		t.Fatal("Logout failed:", err)
+	}
+	if err := <-errc; err != client.ErrNoGrant {
+		t.Errorf("Token during Logout returned err %v, want %v", err, client.ErrNoGrant)
+	}
+	if g := c.Grant(); g != nil {
+		t.Errorf("Grant after Logout = %#v, want nil", g)
+	}
+}
+
+func TestTransport_retryOn401(t *testing.T) {
+	// Access tokens that expire in 45s are not refreshed by the client but
+	// are rejected by the fake API.
+	f := &fakeMonban{t: t, accessDur: 45 * time.Second}
+	srv := httptest.NewServer(f)
+	defer srv.Close()
+
+	c := client.New(srv.URL)
+	if err := c.Login("foo", "bar"); err != nil {
+		t.Fatal("Login failed:", err)
+	}
+	hc := &http.Client{Transport: c.Transport(nil)}
+	resp, err := hc.Get(srv.URL + "/api")
+	if err != nil {
+		t.Fatal("GET failed:", err)
+	}
+	resp.Body.Close()
+	if got, want := resp.StatusCode, http.StatusOK; got != want {
+		t.Errorf("GET status = %d, want %d", got, want)
+	}
+	if got, want := atomic.LoadInt32(&f.refreshes), int32(1); got != want {
+		t.Errorf("server got %d refreshes, want %d", got, want)
+	}
+}
+
+// closeRecorder is a request body that records whether it was closed.
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestTransport_closesBodyOnTokenError(t *testing.T) {
+	c := client.New("http://monban.invalid")
+	body := &closeRecorder{Reader: strings.NewReader("data")}
+	req, err := http.NewRequest("POST", "http://api.invalid", body)
+	if err != nil {
+		t.Fatal("NewRequest failed:", err)
+	}
+	if _, err := c.Transport(nil).RoundTrip(req); err != client.ErrNoGrant {
+		t.Errorf("RoundTrip without login returned err %v, want %v", err, client.ErrNoGrant)
+	}
+	if !body.closed {
+		t.Error("RoundTrip did not close the request body")
+	}
+}
+
+func TestIntrospector_Authenticate(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, ok := r.BasicAuth(); !ok || id != "booru" || secret != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{"message": "invalid client", "code": 401, "error": "invalid_client"})
+			return
+		}
+		if r.PostFormValue("token") != "mbp_valid" {
+			json.NewEncoder(w).Encode(map[string]interface{}{"active": false})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"active":   true,
+			"sub":      "2",
+			"username": "alice",
+			"class":    "user",
+			"scope":    "posts:read posts:write",
+			"aud":      []string{"monban", "booru"},
+			"iss":      "monban",
+		})
+	}))
+	defer srv.Close()
+
+	in := &client.Introspector{BaseURL: srv.URL, ClientID: "booru", ClientSecret: "s3cr3t"}
+	tok, err := in.Authenticate("mbp_valid")
+	if err != nil {
+		t.Fatal("Introspector.Authenticate failed:", err)
+	}
+	if tok.Subject != "2" || tok.Name != "alice" || tok.Class != "user" || !tok.HasScope("posts:write") || !tok.HasAudience("booru") {
+		t.Errorf("Introspector.Authenticate = %#v, want token of alice with scope posts:write for booru", tok)
+	}
+	if _, err := in.Authenticate("mbp_unknown"); err != client.ErrInactiveToken {
+		t.Errorf("Introspector.Authenticate of unknown token returned err %v, want %v", err, client.ErrInactiveToken)
+	}
+
+	in.ClientSecret = "wrong"
+	_, err = in.Authenticate("mbp_valid")
+	if e, ok := err.(*client.Error); !ok || e.Type != "invalid_client" {
+		t.Errorf("Introspector.Authenticate with wrong secret returned err %v, want invalid_client error", err)
+	}
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+// ErrInactiveToken is returned when an introspected token is not active.
+var ErrInactiveToken = errors.New("client: token is not active")
+
+// Introspector asks Monban about tokens that services cannot verify on their
+// own, such as personal access tokens, using token introspection (RFC 7662).
+// It authenticates as a confidential client and can be used as the Opaque
+// authenticator of a middleware.Verifier. It is safe for concurrent use.
+type Introspector struct {
+	// BaseURL is the URL of the Monban server, for example
+	// "https://auth.example.com".
+	BaseURL string
+	// ClientID and ClientSecret are the credentials of the client.
+	ClientID     string
+	ClientSecret string
+	// HTTPClient is used for the requests to Monban. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+}
+
+type introspectResp struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope"`
+	ClientID  string   `json:"client_id"`
+	Username  string   `json:"username"`
+	Class     string   `json:"class"`
+	Admin     bool     `json:"admin"`
+	ExpiresAt int64    `json:"exp"`
+	IssuedAt  int64    `json:"iat"`
+	Subject   string   `json:"sub"`
+	Audience  []string `json:"aud"`
+	Issuer    string   `json:"iss"`
+	ID        string   `json:"jti"`
+}
+
+// Authenticate introspects token and returns what it stands for. It returns
+// ErrInactiveToken if the token is not active.
+func (in *Introspector) Authenticate(token string) (*jwt.Token, error) {
+	form := url.Values{"token": {token}}
+	u := strings.TrimSuffix(in.BaseURL, "/") + "/introspect"
+	req, err := http.NewRequest("POST", u, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(in.ClientID, in.ClientSecret)
+	client := in.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		e := &Error{Code: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil {
+			e.Message = http.StatusText(resp.StatusCode)
+		}
+		return nil, e
+	}
+	ir := new(introspectResp)
+	if err := json.NewDecoder(resp.Body).Decode(ir); err != nil {
+		return nil, fmt.Errorf("client: decode /introspect response: %v", err)
+	}
+	if !ir.Active {
+		return nil, ErrInactiveToken
+	}
+	tok := &jwt.Token{
+		Type:      jwt.TypeAccess,
+		ID:        ir.ID,
+		Issuer:    ir.Issuer,
+		Subject:   ir.Subject,
+		Audience:  ir.Audience,
+		IssuedAt:  ir.IssuedAt,
+		ExpiresAt: ir.ExpiresAt,
+		Name:      ir.Username,
+		Class:     ir.Class,
+		Admin:     ir.Admin,
+		Scope:     strings.Fields(ir.Scope),
+	}
+	if ir.ClientID != "" {
+		tok.Claims = map[string]interface{}{"client_id": ir.ClientID}
+	}
+	return tok, nil
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"net/http"
+)
+
+// Transport is an http.RoundTripper that attaches the access token of a
+// Client to outgoing requests. If a request is rejected with 401, the tokens
+// are refreshed and the request is retried once.
+type Transport struct {
+	Client *Client
+	// Base is the underlying RoundTripper. If nil, http.DefaultTransport is
+	// used.
+	Base http.RoundTripper
+}
+
+// Transport returns a Transport that authenticates requests sent through
+// base with the tokens of c.
+func (c *Client) Transport(base http.RoundTripper) *Transport {
+	return &Transport{Client: c, Base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Client.Token()
+	if err != nil {
+		// The RoundTripper contract requires closing the body even on
+		// errors.
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+	resp, err := t.base().RoundTrip(authorize(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	// The request cannot be retried if its body cannot be read again.
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	if err := t.Client.refresh(token); err != nil {
+		return resp, nil
+	}
+	token, err = t.Client.accessToken()
+	if err != nil {
+		return resp, nil
+	}
+	retry := authorize(req, token)
+	if req.Body != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	resp.Body.Close()
+	return t.base().RoundTrip(retry)
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+	return t.Base
+}
+
+// authorize returns a copy of req with the bearer token set as required by
+// the RoundTripper contract which forbids modifying the request.
+func authorize(req *http.Request, token string) *http.Request {
+	r := req.Clone(req.Context())
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
@@ -9,10 +9,13 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
+	"github.com/kusubooru/monban/jwt"
 	"github.com/kusubooru/monban/monban"
 	"github.com/kusubooru/monban/monban/boltdb"
+	"github.com/kusubooru/monban/monban/mail"
 	"github.com/kusubooru/monban/monban/mysql"
 	"github.com/kusubooru/monban/rest"
 	"github.com/kusubooru/shimmie/store"
@@ -28,11 +31,23 @@ func main() {
 		dataSourceName     = flag.String("datasource", "", "monban database data source")
 		shimmieDriver      = flag.String("shimmiedriver", "mysql", "shimmie database driver")
 		shimmieDataSource  = flag.String("shimmiedatasource", "", "shimmie database data source")
-		secret             = flag.String("secret", "", "secret used to sign JWT tokens")
-		boltFile           = flag.String("boltfile", "monban.db", "BoltDB database file to store token whitelist")
-		monbanIssuer       = flag.String("issuer", "monban", "will appear as the issuer field for created tokens")
+		secret             = flag.String("secret", "", "secret used to sign JWT tokens when -alg is HS256")
+		oldSecrets         = flag.String("oldsecrets", "", "comma separated list of previous secrets that are still accepted for verifying HS256 JWT tokens; remove them after the refresh token lifetime has passed")
+		signingAlg         = flag.String("alg", jwt.HS256, "algorithm used to sign JWT tokens: HS256, RS256, ES256 or EdDSA")
+		signingKeyFile     = flag.String("signkey", "", "private key in PEM format used to sign JWT tokens when -alg is RS256, ES256 or EdDSA")
+		verifyKeyFiles     = flag.String("verifykeys", "", "comma separated list of retired keys in PEM format that are still accepted for verifying JWT tokens; remove them after the refresh token lifetime has passed")
+		boltFile           = flag.String("boltfile", "monban.db", "BoltDB database file to store token whitelist and denylist")
+		useDenylist        = flag.Bool("denylist", true, "keep a denylist of revoked access tokens so that they are rejected before they expire")
+		monbanIssuer       = flag.String("issuer", "monban", "will appear as the issuer field for created tokens; must be the URL monban is served at for OpenID Connect")
+		monbanAudiences    = flag.String("audiences", "", "comma separated list of services that clients can request access tokens for")
+		classScopes        = flag.String("classscopes", "", `scopes the users of each class can be granted in the format "class=scope scope;class=scope"; class "*" applies to unlisted classes`)
 		accessTokenMinutes = flag.Int64("atmins", 15, "minutes for access token to expire")
 		refreshTokenHours  = flag.Int64("rthours", 72, "hours for the refresh token to expire")
+		smtpAddr           = flag.String("smtpaddr", "", "address of the SMTP server used to send password reset emails, for example smtp.example.com:587")
+		smtpUser           = flag.String("smtpuser", "", "username for the SMTP server")
+		smtpPass           = flag.String("smtppass", "", "password for the SMTP server")
+		mailFrom           = flag.String("mailfrom", "", "address password reset emails are sent from")
+		mailFile           = flag.String("mailfile", "", "file to write password reset emails to instead of sending them; for development only")
 		showVersion        = flag.Bool("v", false, "print program version")
 		certFile           = flag.String("tlscert", "", "TLS public key in PEM format.  Must be used together with -tlskey")
 		keyFile            = flag.String("tlskey", "", "TLS private key in PEM format. Must be used together with -tlscert")
@@ -46,9 +61,39 @@ func main() {
 		return
 	}
 
-	if *secret == "" {
-		log.Fatalln("No secret specified, exiting...")
+	var signingKey *jwt.Key
+	var retiredKeys []*jwt.Key
+	switch *signingAlg {
+	case jwt.HS256:
+		if *secret == "" {
+			log.Fatalln("No secret specified, exiting...")
+		}
+		signingKey = jwt.NewHMACKey([]byte(*secret))
+	default:
+		if *signingKeyFile == "" {
+			log.Fatalln("No signing key specified, exiting...")
+		}
+		k, err := jwt.LoadPrivateKey(*signingAlg, *signingKeyFile)
+		if err != nil {
+			log.Fatalln("Loading signing key failed:", err)
+		}
+		signingKey = k
+	}
+	if *oldSecrets != "" {
+		for _, old := range strings.Split(*oldSecrets, ",") {
+			retiredKeys = append(retiredKeys, jwt.NewHMACKey([]byte(old)))
+		}
+	}
+	if *verifyKeyFiles != "" {
+		for _, f := range strings.Split(*verifyKeyFiles, ",") {
+			k, err := jwt.LoadVerifyKey("", f)
+			if err != nil {
+				log.Fatalf("Loading verify key %q failed: %v", f, err)
+			}
+			retiredKeys = append(retiredKeys, k)
+		}
 	}
+	keys := jwt.NewKeySet(signingKey, retiredKeys...)
 	if *shimmieDataSource == "" {
 		log.Fatalln("No shimmie database datasource specified, exiting...")
 	}
@@ -59,11 +104,38 @@ func main() {
 		log.Fatalln("No issuer specified, exiting...")
 	}
 
+	var audiences []string
+	if *monbanAudiences != "" {
+		audiences = strings.Split(*monbanAudiences, ",")
+	}
+
+	scopePolicy, err := monban.ParseScopePolicy(*classScopes)
+	if err != nil {
+		log.Fatalln("Parsing class scopes failed:", err)
+	}
+
+	// Password reset is disabled without a mailer.
+	var mailer monban.Mailer
+	switch {
+	case *smtpAddr != "":
+		if *mailFrom == "" {
+			log.Fatalln("No mail from address specified, exiting...")
+		}
+		mailer = mail.NewSMTP(*smtpAddr, *mailFrom, *smtpUser, *smtpPass)
+	case *mailFile != "":
+		mailer = &mail.File{Path: *mailFile, From: *mailFrom}
+	}
+
 	accessTokenDuration := time.Duration(*accessTokenMinutes) * time.Minute
 	refreshTokenDuration := time.Duration(*refreshTokenHours) * time.Hour
 	if accessTokenDuration <= 0 || refreshTokenDuration <= 0 {
 		log.Fatalln("Token duration cannot be zero or negative, exiting...")
 	}
+	// Refresh tokens issued before token types were introduced are told
+	// apart from access tokens by their lifetime.
+	if accessTokenDuration == refreshTokenDuration {
+		log.Fatalln("Access and refresh token durations cannot be equal, exiting...")
+	}
 
 	// Connect to monban db.
 	monbanDB, err := mysql.OpenMonbanDB(*dataSourceName)
@@ -79,28 +151,43 @@ func main() {
 	// Connect to shimmie db.
 	shimmieDB := store.Open(*shimmieDriver, *shimmieDataSource)
 
-	// Boltdb whitelist.
-	wl := boltdb.NewWhitelist(*boltFile)
+	// Boltdb whitelist, denylist and OAuth stores.
+	bdb := boltdb.Open(*boltFile)
 	defer func() {
-		if err := wl.Close(); err != nil {
-			log.Println("whitelist close failed:", err)
+		if err := bdb.Close(); err != nil {
+			log.Println("bolt close failed:", err)
 		}
 	}()
+	wl := bdb.Whitelist()
 	startWhitelistReap(wl, refreshTokenDuration)
+	startExpiredReap(bdb)
+	var dl monban.Denylist
+	if *useDenylist {
+		dl = bdb.Denylist()
+	}
 
 	// Inject dependencies to monban.
-	authService := monban.NewAuthService(
-		monbanDB,
-		shimmieDB,
-		wl,
-		accessTokenDuration,
-		refreshTokenDuration,
-		*monbanIssuer,
-		*secret,
-	)
-	handlers := rest.NewServer(authService)
+	authService := monban.NewAuthService(monban.Config{
+		Users:                monbanDB,
+		Clients:              monbanDB,
+		PersonalTokens:       monbanDB,
+		Shimmie:              shimmieDB,
+		Whitelist:            wl,
+		Denylist:             dl,
+		Codes:                bdb.CodeStore(),
+		Devices:              bdb.DeviceStore(),
+		Resets:               bdb.ResetStore(),
+		Mailer:               mailer,
+		AccessTokenDuration:  accessTokenDuration,
+		RefreshTokenDuration: refreshTokenDuration,
+		Issuer:               *monbanIssuer,
+		Audiences:            audiences,
+		Scopes:               scopePolicy,
+		Keys:                 keys,
+	})
+	handlers := rest.NewServer(authService, keys, *monbanIssuer)
 
-	closeOnSignal(monbanDB, wl)
+	closeOnSignal(handlers, monbanDB, bdb)
 
 	useTLS = *certFile != "" && *keyFile != ""
 	if useTLS {
@@ -113,13 +200,18 @@ func main() {
 	}
 }
 
-func closeOnSignal(monbanDB *mysql.MonbanDB, wl *boltdb.Whitelist) {
+func closeOnSignal(handlers rest.Server, monbanDB *mysql.MonbanDB, bdb *boltdb.DB) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt, os.Kill)
 	go func() {
 		for sig := range c {
 			log.Printf("%v signal received, releasing database resources and exiting...", sig)
-			if err := wl.Close(); err != nil {
+			// Send the pending password reset emails while the databases
+			// are still open.
+			if err := handlers.Close(); err != nil {
+				log.Println("server close failed:", err)
+			}
+			if err := bdb.Close(); err != nil {
 				log.Println("bolt close failed:", err)
 			}
 			if err := monbanDB.Close(); err != nil {
@@ -137,3 +229,12 @@ func startWhitelistReap(wl *boltdb.Whitelist, duration time.Duration) {
 		}
 	}()
 }
+
+// expiredReapInterval is how often the expired denylist entries, codes,
+// devices, resets and sessions are removed. They are checked on use so a
+// late removal only costs disk space.
+const expiredReapInterval = time.Minute
+
+func startExpiredReap(bdb *boltdb.DB) {
+	go bdb.ReapExpired(expiredReapInterval)
+}
@@ -0,0 +1,48 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// signingMethodEdDSA implements the EdDSA signing method using Ed25519 keys
+// as the jwt-go package does not provide one.
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(EdDSA, func() jwt.SigningMethod {
+		return signingMethodEdDSA{}
+	})
+}
+
+func (m signingMethodEdDSA) Alg() string {
+	return EdDSA
+}
+
+// Verify implements the Verify method from jwt.SigningMethod. For this
+// verify method, key must be an ed25519.PublicKey.
+func (m signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	pub, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Sign implements the Sign method from jwt.SigningMethod. For this signing
+// method, key must be an ed25519.PrivateKey.
+func (m signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(priv, []byte(signingString))), nil
+}
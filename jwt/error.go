@@ -0,0 +1,98 @@
+package jwt
+
+import (
+	"errors"
+	"fmt"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// ErrorKind describes why a token was rejected.
+type ErrorKind int
+
+// The reasons a token can be rejected for.
+const (
+	Malformed ErrorKind = iota + 1
+	Expired
+	NotValidYet
+	BadSignature
+	WrongAlgorithm
+	MissingClaim
+	InvalidAudience
+)
+
+var kindCodes = map[ErrorKind]string{
+	Malformed:       "token_malformed",
+	Expired:         "token_expired",
+	NotValidYet:     "token_not_yet_valid",
+	BadSignature:    "token_bad_signature",
+	WrongAlgorithm:  "token_wrong_algorithm",
+	MissingClaim:    "token_missing_claim",
+	InvalidAudience: "token_invalid_audience",
+}
+
+// String returns a machine readable code for the kind of error, for example
+// "token_expired".
+func (k ErrorKind) String() string {
+	if c, ok := kindCodes[k]; ok {
+		return c
+	}
+	return "token_invalid"
+}
+
+// Error is returned when a token is rejected. Its Kind allows clients to
+// decide if they should refresh their tokens or log in again.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Kind.String()
+	}
+	return fmt.Sprintf("%v: %v", e.Kind, e.Err)
+}
+
+// IsKind reports whether err is an *Error of the given kind.
+func IsKind(err error, kind ErrorKind) bool {
+	e, ok := err.(*Error)
+	return ok && e.Kind == kind
+}
+
+// validationError converts an error returned by the jwt-go parser to an
+// *Error.
+func validationError(err error) error {
+	ve, ok := err.(*jwt.ValidationError)
+	if !ok {
+		return &Error{Kind: Malformed, Err: err}
+	}
+	// Errors returned by the key lookup are kept as they are.
+	if e, ok := ve.Inner.(*Error); ok {
+		return e
+	}
+	// Signature errors are reported before errors about the claims so that
+	// nothing is revealed about the claims of forged tokens.
+	switch {
+	case ve.Errors&jwt.ValidationErrorMalformed != 0:
+		return &Error{Kind: Malformed, Err: err}
+	case ve.Errors&jwt.ValidationErrorUnverifiable != 0:
+		return &Error{Kind: WrongAlgorithm, Err: err}
+	case ve.Errors&jwt.ValidationErrorSignatureInvalid != 0:
+		return &Error{Kind: BadSignature, Err: err}
+	case ve.Errors&jwt.ValidationErrorExpired != 0:
+		return &Error{Kind: Expired, Err: err}
+	case ve.Errors&(jwt.ValidationErrorNotValidYet|jwt.ValidationErrorIssuedAt) != 0:
+		return &Error{Kind: NotValidYet, Err: err}
+	}
+	return &Error{Kind: Malformed, Err: err}
+}
+
+// errMissingClaim returns an error about a missing claim.
+func errMissingClaim(claim string) error {
+	return &Error{Kind: MissingClaim, Err: fmt.Errorf("missing %q claim", claim)}
+}
+
+// errWrongAlgorithm is returned when a token is not signed with the algorithm
+// of the key that is supposed to verify it.
+var errWrongAlgorithm = &Error{Kind: WrongAlgorithm, Err: errors.New("unexpected signing method")}
@@ -0,0 +1,147 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// JWK is a public key in JSON Web Key format (RFC 7517).
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	// RSA keys.
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP keys.
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// JWKS returns the public keys of the set so that they can be published to
+// the services that verify tokens. Symmetric keys are never published.
+func (ks *KeySet) JWKS() *JWKS {
+	set := &JWKS{Keys: []*JWK{}}
+	for _, k := range ks.Keys() {
+		jwk, err := k.JWK()
+		if err != nil {
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// JWK returns the public part of the key in JSON Web Key format. It returns
+// an error for symmetric keys.
+func (k *Key) JWK() (*JWK, error) {
+	jwk, err := publicJWK(k.verifyKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk.Kid = k.ID
+	jwk.Use = "sig"
+	jwk.Alg = k.Alg
+	return jwk, nil
+}
+
+// publicJWK returns the members of a public key that are used to compute its
+// thumbprint.
+func publicJWK(key interface{}) (*JWK, error) {
+	switch pub := key.(type) {
+	case *rsa.PublicKey:
+		return &JWK{
+			Kty: "RSA",
+			N:   encodeBytes(pub.N.Bytes()),
+			E:   encodeBytes(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return &JWK{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   encodeBytes(padBytes(pub.X.Bytes(), size)),
+			Y:   encodeBytes(padBytes(pub.Y.Bytes(), size)),
+		}, nil
+	case ed25519.PublicKey:
+		return &JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   encodeBytes(pub),
+		}, nil
+	}
+	return nil, fmt.Errorf("key has no public JWK representation")
+}
+
+// Thumbprint returns the JWK thumbprint of the key as defined by RFC 7638.
+func (k *Key) Thumbprint() (string, error) {
+	jwk, err := publicJWK(k.verifyKey)
+	if err != nil {
+		return "", err
+	}
+	// The required members in lexicographic order.
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	case "EC":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+	case "OKP":
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+	b, err := json.Marshal(members)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return encodeBytes(sum[:]), nil
+}
+
+func (k *Key) setThumbprintID() error {
+	id, err := k.Thumbprint()
+	if err != nil {
+		return err
+	}
+	k.ID = id
+	return nil
+}
+
+func encodeBytes(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// padBytes left pads b with zeros up to size bytes.
+func padBytes(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	p := make([]byte, size)
+	copy(p[size-len(b):], b)
+	return p
+}
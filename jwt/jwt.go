@@ -1,35 +1,214 @@
 package jwt
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	jwt "github.com/dgrijalva/jwt-go"
 	uuid "github.com/satori/go.uuid"
 )
 
+// Token types tell apart the tokens that are issued by Monban.
+const (
+	TypeAccess  = "access"
+	TypeRefresh = "refresh"
+	TypeID      = "id"
+)
+
 // Token is a JSON Web Token.
 type Token struct {
+	// Type is one of TypeAccess, TypeRefresh or TypeID. Tokens issued before
+	// types were introduced have none.
+	Type      string
 	ID        string
 	Issuer    string
 	Subject   string
-	Audience  string
+	Audience  []string
 	IssuedAt  int64
 	ExpiresAt int64
 	Duration  time.Duration
 	CSRF      string
+	// Family identifies the chain of refresh tokens that were created from
+	// the same login by rotating them.
+	Family string
+	// Generation is the revocation generation of the subject when the
+	// access token was issued. Revoking all the tokens of a subject moves it
+	// to the next generation which denies the tokens of the earlier ones.
+	Generation int64
+	// Name, Class and Admin describe the user the token was issued to.
+	Name  string
+	Class string
+	Admin bool
+	// Scope lists what the token grants access to.
+	Scope []string
+	// Claims holds any private claims that do not have a dedicated field.
+	// Claims that collide with the ones above are ignored. After decoding,
+	// JSON numbers are float64 values.
+	Claims map[string]interface{}
 }
 
 type myCustomClaims struct {
-	CSRF string `json:"csrf,omitempty"`
+	Type   string `json:"typ,omitempty"`
+	CSRF   string `json:"csrf,omitempty"`
+	Family string `json:"fam,omitempty"`
+	Gen    int64  `json:"gen,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Class  string `json:"class,omitempty"`
+	Admin  bool   `json:"admin,omitempty"`
+	// Audience shadows the audience of jwt.StandardClaims which can only
+	// hold a single value.
+	Audience audience `json:"aud,omitempty"`
+	Scope    scope    `json:"scope,omitempty"`
 	jwt.StandardClaims
+	private map[string]interface{}
+	leeway  time.Duration
+}
+
+// Valid validates the time based claims allowing for the leeway of the claims.
+func (c *myCustomClaims) Valid() error {
+	if c.leeway == 0 {
+		return c.StandardClaims.Valid()
+	}
+	now := jwt.TimeFunc().Unix()
+	leeway := int64(c.leeway / time.Second)
+	vErr := new(jwt.ValidationError)
+	if !c.VerifyExpiresAt(now-leeway, false) {
+		vErr.Inner = fmt.Errorf("token is expired")
+		vErr.Errors |= jwt.ValidationErrorExpired
+	}
+	if !c.VerifyIssuedAt(now+leeway, false) {
+		vErr.Inner = fmt.Errorf("token used before issued")
+		vErr.Errors |= jwt.ValidationErrorIssuedAt
+	}
+	if !c.VerifyNotBefore(now+leeway, false) {
+		vErr.Inner = fmt.Errorf("token is not valid yet")
+		vErr.Errors |= jwt.ValidationErrorNotValidYet
+	}
+	if vErr.Errors == 0 {
+		return nil
+	}
+	return vErr
+}
+
+// audience is the "aud" claim which is encoded as a single string when it
+// holds one value and as an array otherwise.
+type audience []string
+
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *audience) UnmarshalJSON(b []byte) error {
+	var single string
+	if err := json.Unmarshal(b, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(b, &multiple); err != nil {
+		return err
+	}
+	*a = audience(multiple)
+	return nil
+}
+
+// scope is the "scope" claim which is encoded as a space separated string as
+// defined by RFC 8693.
+type scope []string
+
+func (s scope) MarshalJSON() ([]byte, error) {
+	return json.Marshal(strings.Join(s, " "))
+}
+
+func (s *scope) UnmarshalJSON(b []byte) error {
+	var joined string
+	if err := json.Unmarshal(b, &joined); err != nil {
+		return err
+	}
+	*s = nil
+	if fields := strings.Fields(joined); len(fields) != 0 {
+		*s = scope(fields)
+	}
+	return nil
 }
 
-// Encode encodes and signs a JWT token.
+// knownClaims are the claims that are encoded by the myCustomClaims fields.
+var knownClaims = []string{
+	"typ", "csrf", "fam", "gen", "name", "class", "admin", "scope",
+	"aud", "exp", "jti", "iat", "iss", "nbf", "sub",
+}
+
+// claims is used to encode and decode the fields of myCustomClaims without
+// recursing into its MarshalJSON and UnmarshalJSON methods.
+type claims myCustomClaims
+
+// MarshalJSON encodes the known claims together with the private ones.
+func (c myCustomClaims) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(claims(c))
+	if err != nil || len(c.private) == 0 {
+		return b, err
+	}
+	m := make(map[string]interface{})
+	for k, v := range c.private {
+		m[k] = v
+	}
+	// Known claims always win over private ones with the same name, even
+	// when they are empty and therefore omitted from b.
+	for _, k := range knownClaims {
+		delete(m, k)
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// UnmarshalJSON decodes the known claims and collects any other claims as
+// private ones.
+func (c *myCustomClaims) UnmarshalJSON(b []byte) error {
+	if err := json.Unmarshal(b, (*claims)(c)); err != nil {
+		return err
+	}
+	m := make(map[string]interface{})
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	for _, k := range knownClaims {
+		delete(m, k)
+	}
+	c.private = nil
+	if len(m) != 0 {
+		c.private = m
+	}
+	return nil
+}
+
+// Encode encodes and signs a JWT token using HS256 and secret.
 func Encode(t *Token, secret []byte) (string, error) {
+	return EncodeWithKey(t, NewHMACKey(secret))
+}
+
+// EncodeWithKey encodes and signs a JWT token using key k.
+func EncodeWithKey(t *Token, k *Key) (string, error) {
+	if !k.CanSign() {
+		return "", fmt.Errorf("sign token failed: key cannot be used for signing")
+	}
 	claims := myCustomClaims{
-		CSRF: t.CSRF,
+		Type:     t.Type,
+		CSRF:     t.CSRF,
+		Family:   t.Family,
+		Gen:      t.Generation,
+		Name:     t.Name,
+		Class:    t.Class,
+		Admin:    t.Admin,
+		Audience: audience(t.Audience),
+		Scope:    scope(t.Scope),
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: t.ExpiresAt,
 			IssuedAt:  t.IssuedAt,
@@ -37,10 +216,14 @@ func Encode(t *Token, secret []byte) (string, error) {
 			Subject:   t.Subject,
 			Id:        t.ID,
 		},
+		private: t.Claims,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	ss, err := token.SignedString(secret)
+	token := jwt.NewWithClaims(k.method(), &claims)
+	if k.ID != "" {
+		token.Header["kid"] = k.ID
+	}
+	ss, err := token.SignedString(k.signKey)
 	if err != nil {
 		return "", fmt.Errorf("sign token failed: %v", err)
 	}
@@ -48,51 +231,136 @@ func Encode(t *Token, secret []byte) (string, error) {
 }
 
 var (
-	// ErrInvalidToken returned by Decode when the token is invalid.
-	ErrInvalidToken = errors.New("invalid token")
+	// ErrInvalidAudience is returned by VerifyAudience when the token is not
+	// intended for the verifier.
+	ErrInvalidAudience = &Error{Kind: InvalidAudience, Err: errors.New("invalid audience")}
 )
 
-// Decode parses a token from a string format and returns a Token struct.
-func Decode(t string, secret []byte) (*Token, bool, error) {
-	parsedToken, err := jwt.ParseWithClaims(t, &myCustomClaims{}, func(token *jwt.Token) (interface{}, error) {
+// HasAudience reports whether aud is one of the audiences of the token.
+func (t *Token) HasAudience(aud string) bool {
+	for _, a := range t.Audience {
+		if a == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the token grants scope.
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scope {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyAudience makes sure that the token is intended for at least one of
+// the audiences a verifier identifies as. It returns ErrInvalidAudience
+// otherwise.
+func VerifyAudience(t *Token, aud ...string) error {
+	for _, a := range aud {
+		if t.HasAudience(a) {
+			return nil
+		}
+	}
+	return ErrInvalidAudience
+}
+
+// Decode parses a token from a string format and returns a Token struct. The
+// token must be signed using HS256 and either secret or one of the previous
+// secrets. Rejected tokens result in an *Error that describes the reason.
+func Decode(t string, secret []byte, previous ...[]byte) (*Token, bool, error) {
+	return NewHMACKeySet(secret, previous...).Decode(t)
+}
+
+// DecodeWithKey parses a token from a string format and returns a Token
+// struct. The token must be signed with the algorithm and key of k.
+func DecodeWithKey(t string, k *Key) (*Token, bool, error) {
+	return decodeWithKey(t, k, 0)
+}
+
+func decodeWithKey(t string, k *Key, leeway time.Duration) (*Token, bool, error) {
+	return decode(t, leeway, func(alg, kid string) (*Key, error) {
 		// Make sure token's signature wasn't changed.
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected siging method")
+		if alg != k.Alg {
+			return nil, errWrongAlgorithm
 		}
-		return secret, nil
+		return k, nil
 	})
-	if err != nil {
-		if ve, ok := err.(*jwt.ValidationError); ok {
-			if ve.Errors&jwt.ValidationErrorMalformed != 0 {
-				return nil, false, ErrInvalidToken
-			} else if ve.Errors&(jwt.ValidationErrorExpired|jwt.ValidationErrorNotValidYet) != 0 {
-				// Token is either expired or not active yet.
-				return nil, false, ErrInvalidToken
-			} else {
-				return nil, false, fmt.Errorf("could not handle this token: %v", err)
-			}
+}
+
+// decode parses a token from a string format using lookup to find the key
+// that verifies the token based on the "alg" and "kid" headers. The time based
+// claims are validated allowing for leeway of clock skew.
+func decode(t string, leeway time.Duration, lookup func(alg, kid string) (*Key, error)) (*Token, bool, error) {
+	parsedToken, err := jwt.ParseWithClaims(t, &myCustomClaims{leeway: leeway}, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		k, err := lookup(token.Method.Alg(), kid)
+		if err != nil {
+			return nil, err
 		}
-		return nil, false, fmt.Errorf("failed to decode claims: %v", err)
+		return k.verifyKey, nil
+	})
+	if err != nil {
+		return nil, false, validationError(err)
 	}
 	claims, ok := parsedToken.Claims.(*myCustomClaims)
 	if !ok {
-		return nil, parsedToken.Valid, fmt.Errorf("failed to decode custom claims")
+		return nil, false, &Error{Kind: Malformed, Err: fmt.Errorf("failed to decode custom claims")}
 	}
 	sc := claims.StandardClaims
+	if sc.ExpiresAt == 0 {
+		return nil, false, errMissingClaim("exp")
+	}
+	if sc.Subject == "" {
+		return nil, false, errMissingClaim("sub")
+	}
+	return claims.token(), parsedToken.Valid, nil
+}
+
+// token converts the decoded claims to a Token.
+func (c *myCustomClaims) token() *Token {
+	sc := c.StandardClaims
 	issuedAt := time.Unix(sc.IssuedAt, 0)
 	expiresAt := time.Unix(sc.ExpiresAt, 0)
 	duration := expiresAt.Sub(issuedAt)
 
-	token := &Token{
-		CSRF:      claims.CSRF,
-		ID:        sc.Id,
-		Issuer:    sc.Issuer,
-		Subject:   sc.Subject,
-		Duration:  duration,
-		IssuedAt:  sc.IssuedAt,
-		ExpiresAt: sc.ExpiresAt,
+	return &Token{
+		Type:       c.Type,
+		CSRF:       c.CSRF,
+		Family:     c.Family,
+		Generation: c.Gen,
+		Name:       c.Name,
+		Class:      c.Class,
+		Admin:      c.Admin,
+		Claims:     c.private,
+		Audience:   []string(c.Audience),
+		Scope:      []string(c.Scope),
+		ID:         sc.Id,
+		Issuer:     sc.Issuer,
+		Subject:    sc.Subject,
+		Duration:   duration,
+		IssuedAt:   sc.IssuedAt,
+		ExpiresAt:  sc.ExpiresAt,
+	}
+}
+
+// DecodeUnverified decodes the claims of a token without verifying its
+// signature or validity. It is meant for clients that need to inspect their
+// own tokens, for example to find out when they expire, and must never be
+// used to authenticate requests.
+func DecodeUnverified(t string) (*Token, error) {
+	parsedToken, _, err := new(jwt.Parser).ParseUnverified(t, &myCustomClaims{})
+	if err != nil {
+		return nil, &Error{Kind: Malformed, Err: err}
+	}
+	claims, ok := parsedToken.Claims.(*myCustomClaims)
+	if !ok {
+		return nil, &Error{Kind: Malformed, Err: fmt.Errorf("failed to decode custom claims")}
 	}
-	return token, parsedToken.Valid, nil
+	return claims.token(), nil
 }
 
 // NewUUID provides a new UUID.
@@ -1,6 +1,7 @@
 package jwt_test
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -18,7 +19,215 @@ func TestEncode(t *testing.T) {
 	for _, tt := range tests {
 		_, err := jwt.Encode(tt.token, tt.secret)
 		if err != nil {
-			t.Errorf("jwt.Encode(%q, %q) returned err: %v", tt.token, tt.secret, err)
+			t.Errorf("jwt.Encode(%#v, %q) returned err: %v", tt.token, tt.secret, err)
 		}
 	}
 }
+
+func TestDecode_userClaims(t *testing.T) {
+	now := time.Now()
+	secret := []byte("secret")
+	tok := &jwt.Token{
+		Type:      jwt.TypeAccess,
+		Subject:   "2",
+		Issuer:    "issuer",
+		Name:      "foo",
+		Class:     "user",
+		Admin:     true,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(5 * time.Minute).Unix(),
+		Claims: map[string]interface{}{
+			"lang": "en",
+			"name": "ignored",
+		},
+	}
+	s, err := jwt.Encode(tok, secret)
+	if err != nil {
+		t.Fatal("jwt.Encode failed:", err)
+	}
+	got, valid, err := jwt.Decode(s, secret)
+	if err != nil {
+		t.Fatal("jwt.Decode failed:", err)
+	}
+	if !valid {
+		t.Fatal("jwt.Decode returned invalid token")
+	}
+	if got.Type != jwt.TypeAccess {
+		t.Errorf("jwt.Decode type = %q, want %q", got.Type, jwt.TypeAccess)
+	}
+	if got.Name != "foo" || got.Class != "user" || !got.Admin {
+		t.Errorf("jwt.Decode user claims = (%q, %q, %v), want (%q, %q, %v)", got.Name, got.Class, got.Admin, "foo", "user", true)
+	}
+	want := map[string]interface{}{"lang": "en"}
+	if !reflect.DeepEqual(got.Claims, want) {
+		t.Errorf("jwt.Decode private claims = %#v, want %#v", got.Claims, want)
+	}
+}
+
+func TestDecode_audience(t *testing.T) {
+	now := time.Now()
+	secret := []byte("secret")
+	tests := []struct {
+		aud []string
+	}{
+		{nil},
+		{[]string{"wiki"}},
+		{[]string{"wiki", "grafana"}},
+	}
+	for _, tt := range tests {
+		tok := &jwt.Token{Subject: "1", Audience: tt.aud, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+		s, err := jwt.Encode(tok, secret)
+		if err != nil {
+			t.Fatal("jwt.Encode failed:", err)
+		}
+		got, _, err := jwt.Decode(s, secret)
+		if err != nil {
+			t.Fatal("jwt.Decode failed:", err)
+		}
+		if !reflect.DeepEqual(got.Audience, tt.aud) {
+			t.Errorf("jwt.Decode audience = %q, want %q", got.Audience, tt.aud)
+		}
+	}
+}
+
+func TestVerifyAudience(t *testing.T) {
+	tok := &jwt.Token{Audience: []string{"wiki", "grafana"}}
+	tests := []struct {
+		aud  []string
+		want error
+	}{
+		{[]string{"wiki"}, nil},
+		{[]string{"booru", "grafana"}, nil},
+		{[]string{"booru"}, jwt.ErrInvalidAudience},
+		{nil, jwt.ErrInvalidAudience},
+	}
+	for _, tt := range tests {
+		if got := jwt.VerifyAudience(tok, tt.aud...); got != tt.want {
+			t.Errorf("jwt.VerifyAudience(%q, %q) = %v, want %v", tok.Audience, tt.aud, got, tt.want)
+		}
+	}
+}
+
+func TestDecode_errors(t *testing.T) {
+	now := time.Now()
+	secret := []byte("secret")
+	encode := func(tok *jwt.Token, secret []byte) string {
+		s, err := jwt.Encode(tok, secret)
+		if err != nil {
+			t.Fatal("jwt.Encode failed:", err)
+		}
+		return s
+	}
+	valid := &jwt.Token{Subject: "1", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+	tests := []struct {
+		token string
+		want  jwt.ErrorKind
+	}{
+		{"not.a.token", jwt.Malformed},
+		{encode(&jwt.Token{Subject: "1", ExpiresAt: now.Add(-time.Minute).Unix()}, secret), jwt.Expired},
+		{encode(&jwt.Token{Subject: "1", IssuedAt: now.Add(time.Hour).Unix(), ExpiresAt: now.Add(2 * time.Hour).Unix()}, secret), jwt.NotValidYet},
+		{encode(valid, []byte("other")), jwt.BadSignature},
+		{encode(&jwt.Token{Subject: "1"}, secret), jwt.MissingClaim},
+	}
+	for _, tt := range tests {
+		_, _, err := jwt.Decode(tt.token, secret)
+		if !jwt.IsKind(err, tt.want) {
+			t.Errorf("jwt.Decode(%q) returned err %v, want kind %v", tt.token, err, tt.want)
+		}
+	}
+
+	privPEM, _ := generatePEM(t, jwt.RS256)
+	key, err := jwt.ParsePrivateKey(jwt.RS256, privPEM)
+	if err != nil {
+		t.Fatal("jwt.ParsePrivateKey failed:", err)
+	}
+	s, err := jwt.EncodeWithKey(valid, key)
+	if err != nil {
+		t.Fatal("jwt.EncodeWithKey failed:", err)
+	}
+	if _, _, err := jwt.Decode(s, secret); !jwt.IsKind(err, jwt.WrongAlgorithm) {
+		t.Errorf("jwt.Decode of RS256 token with secret returned err %v, want kind %v", err, jwt.WrongAlgorithm)
+	}
+}
+
+func TestAccessTokenHash(t *testing.T) {
+	// Example from OpenID Connect Core 1.0, Appendix A.3.
+	got, err := jwt.AccessTokenHash(jwt.RS256, "jHkWEdUXMU1BwAsC4vtUsZwnNvTIxEl0z9K3vx5KF0Y")
+	if err != nil {
+		t.Fatal("AccessTokenHash failed:", err)
+	}
+	if want := "77QmUPtjPfzWtF2AnpK9RQ"; got != want {
+		t.Errorf("AccessTokenHash = %q, want %q", got, want)
+	}
+}
+
+func TestDecode_scope(t *testing.T) {
+	now := time.Now()
+	secret := []byte("secret")
+	tests := []struct {
+		scope []string
+	}{
+		{[]string{"posts:read"}},
+		{[]string{"posts:read", "tags:write"}},
+		{nil},
+	}
+	for _, tt := range tests {
+		tok := &jwt.Token{Subject: "2", Scope: tt.scope, IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+		s, err := jwt.Encode(tok, secret)
+		if err != nil {
+			t.Fatal("jwt.Encode failed:", err)
+		}
+		got, _, err := jwt.Decode(s, secret)
+		if err != nil {
+			t.Fatal("jwt.Decode failed:", err)
+		}
+		if !reflect.DeepEqual(got.Scope, tt.scope) {
+			t.Errorf("jwt.Decode scope = %q, want %q", got.Scope, tt.scope)
+		}
+		if got.Claims != nil {
+			t.Errorf("jwt.Decode private claims = %#v, want none", got.Claims)
+		}
+	}
+}
+
+func TestEncode_privateClaimCollision(t *testing.T) {
+	now := time.Now()
+	secret := []byte("secret")
+	tok := &jwt.Token{
+		Type:      jwt.TypeRefresh,
+		Subject:   "2",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(5 * time.Minute).Unix(),
+		Claims: map[string]interface{}{
+			"admin": true,
+			"typ":   jwt.TypeAccess,
+			"csrf":  "forged",
+			"nbf":   now.Add(time.Hour).Unix(),
+			"lang":  "en",
+		},
+	}
+	s, err := jwt.Encode(tok, secret)
+	if err != nil {
+		t.Fatal("jwt.Encode failed:", err)
+	}
+	got, valid, err := jwt.Decode(s, secret)
+	if err != nil {
+		t.Fatal("jwt.Decode failed:", err)
+	}
+	if !valid {
+		t.Fatal("jwt.Decode returned invalid token")
+	}
+	if got.Admin {
+		t.Errorf("jwt.Decode admin = %v, want %v", got.Admin, false)
+	}
+	if got.Type != jwt.TypeRefresh {
+		t.Errorf("jwt.Decode type = %q, want %q", got.Type, jwt.TypeRefresh)
+	}
+	if got.CSRF != "" {
+		t.Errorf("jwt.Decode csrf = %q, want %q", got.CSRF, "")
+	}
+	want := map[string]interface{}{"lang": "en"}
+	if !reflect.DeepEqual(got.Claims, want) {
+		t.Errorf("jwt.Decode private claims = %#v, want %#v", got.Claims, want)
+	}
+}
@@ -0,0 +1,213 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Supported signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	ES256 = "ES256"
+	EdDSA = "EdDSA"
+)
+
+// Key is used to sign and verify tokens with a specific algorithm. Keys that
+// only hold a public key can verify tokens but cannot sign them.
+type Key struct {
+	// ID is stamped as the "kid" header of the signed tokens. Asymmetric keys
+	// get their JWK thumbprint as ID when parsed.
+	ID        string
+	Alg       string
+	signKey   interface{}
+	verifyKey interface{}
+}
+
+// NewHMACKey returns a key that signs and verifies tokens using HS256 and
+// secret. The ID of the key is derived from the secret so that tokens can be
+// matched with the secret that signed them when secrets are rotated.
+func NewHMACKey(secret []byte) *Key {
+	sum := sha256.Sum256(secret)
+	id := base64.RawURLEncoding.EncodeToString(sum[:8])
+	return &Key{ID: id, Alg: HS256, signKey: secret, verifyKey: secret}
+}
+
+// CanSign reports whether the key holds the private part needed for signing.
+func (k *Key) CanSign() bool {
+	return k.signKey != nil
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Alg)
+}
+
+// ParsePrivateKey parses a PEM encoded private key to be used with alg. The
+// returned key can both sign and verify tokens. PKCS #8, PKCS #1 (RSA) and
+// SEC 1 (ECDSA) encodings are supported. If alg is empty, it is inferred from
+// the type of the key.
+func ParsePrivateKey(alg string, data []byte) (*Key, error) {
+	k, err := parsePrivateKey(alg, data)
+	if err != nil {
+		return nil, err
+	}
+	return k, k.setThumbprintID()
+}
+
+// ParsePublicKey parses a PEM encoded public key to be used with alg. The
+// returned key can only verify tokens. PKIX, PKCS #1 (RSA) and certificate
+// encodings are supported. If alg is empty, it is inferred from the type of
+// the key.
+func ParsePublicKey(alg string, data []byte) (*Key, error) {
+	k, err := parsePublicKey(alg, data)
+	if err != nil {
+		return nil, err
+	}
+	return k, k.setThumbprintID()
+}
+
+func parsePrivateKey(alg string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	var parsed interface{}
+	var err error
+	if parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if parsed, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("unknown private key format")
+			}
+		}
+	}
+
+	if alg == "" {
+		alg = inferAlg(parsed)
+	}
+	switch priv := parsed.(type) {
+	case *rsa.PrivateKey:
+		if alg == RS256 {
+			return &Key{Alg: alg, signKey: priv, verifyKey: &priv.PublicKey}, nil
+		}
+	case *ecdsa.PrivateKey:
+		if alg == ES256 {
+			if err := checkCurve(&priv.PublicKey); err != nil {
+				return nil, err
+			}
+			return &Key{Alg: alg, signKey: priv, verifyKey: &priv.PublicKey}, nil
+		}
+	case ed25519.PrivateKey:
+		if alg == EdDSA {
+			return &Key{Alg: alg, signKey: priv, verifyKey: priv.Public()}, nil
+		}
+	}
+	return nil, fmt.Errorf("private key cannot be used with signing algorithm %q", alg)
+}
+
+func parsePublicKey(alg string, data []byte) (*Key, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, jwt.ErrKeyMustBePEMEncoded
+	}
+	var parsed interface{}
+	var err error
+	if parsed, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		if parsed, err = x509.ParsePKCS1PublicKey(block.Bytes); err != nil {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("unknown public key format")
+			}
+			parsed = cert.PublicKey
+		}
+	}
+
+	if alg == "" {
+		alg = inferAlg(parsed)
+	}
+	switch pub := parsed.(type) {
+	case *rsa.PublicKey:
+		if alg == RS256 {
+			return &Key{Alg: alg, verifyKey: pub}, nil
+		}
+	case *ecdsa.PublicKey:
+		if alg == ES256 {
+			if err := checkCurve(pub); err != nil {
+				return nil, err
+			}
+			return &Key{Alg: alg, verifyKey: pub}, nil
+		}
+	case ed25519.PublicKey:
+		if alg == EdDSA {
+			return &Key{Alg: alg, verifyKey: pub}, nil
+		}
+	}
+	return nil, fmt.Errorf("public key cannot be used with signing algorithm %q", alg)
+}
+
+// inferAlg returns the signing algorithm that is used with a parsed key.
+func inferAlg(key interface{}) string {
+	switch key.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return RS256
+	case *ecdsa.PrivateKey, *ecdsa.PublicKey:
+		return ES256
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return EdDSA
+	}
+	return ""
+}
+
+// LoadPrivateKey reads and parses a PEM encoded private key file.
+func LoadPrivateKey(alg, file string) (*Key, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(alg, data)
+}
+
+// LoadPublicKey reads and parses a PEM encoded public key file.
+func LoadPublicKey(alg, file string) (*Key, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(alg, data)
+}
+
+// LoadVerifyKey reads a PEM encoded file that contains either a public or a
+// private key and returns a key that can only verify tokens. It is meant for
+// loading retired keys.
+func LoadVerifyKey(alg, file string) (*Key, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	k, err := ParsePublicKey(alg, data)
+	if err != nil {
+		priv, perr := ParsePrivateKey(alg, data)
+		if perr != nil {
+			return nil, err
+		}
+		k = &Key{ID: priv.ID, Alg: priv.Alg, verifyKey: priv.verifyKey}
+	}
+	return k, nil
+}
+
+// checkCurve makes sure that an ECDSA key can be used with ES256.
+func checkCurve(pub *ecdsa.PublicKey) error {
+	if pub.Curve != elliptic.P256() {
+		return fmt.Errorf("ES256 requires a P-256 key")
+	}
+	return nil
+}
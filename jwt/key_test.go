@@ -0,0 +1,92 @@
+package jwt_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+// generatePEM generates a key pair for alg and returns the private and public
+// keys in PEM format.
+func generatePEM(t *testing.T, alg string) (priv, pub []byte) {
+	var privKey, pubKey interface{}
+	switch alg {
+	case jwt.RS256:
+		k, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			t.Fatal("rsa.GenerateKey failed:", err)
+		}
+		privKey, pubKey = k, &k.PublicKey
+	case jwt.ES256:
+		k, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal("ecdsa.GenerateKey failed:", err)
+		}
+		privKey, pubKey = k, &k.PublicKey
+	case jwt.EdDSA:
+		pk, k, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal("ed25519.GenerateKey failed:", err)
+		}
+		privKey, pubKey = k, pk
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(privKey)
+	if err != nil {
+		t.Fatal("x509.MarshalPKCS8PrivateKey failed:", err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(pubKey)
+	if err != nil {
+		t.Fatal("x509.MarshalPKIXPublicKey failed:", err)
+	}
+	priv = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	pub = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	return priv, pub
+}
+
+func TestEncodeWithKey(t *testing.T) {
+	now := time.Now()
+	tok := &jwt.Token{
+		Subject:   "1",
+		Issuer:    "issuer",
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(5 * time.Minute).Unix(),
+	}
+	for _, alg := range []string{jwt.RS256, jwt.ES256, jwt.EdDSA} {
+		privPEM, pubPEM := generatePEM(t, alg)
+		priv, err := jwt.ParsePrivateKey(alg, privPEM)
+		if err != nil {
+			t.Fatalf("jwt.ParsePrivateKey(%q) failed: %v", alg, err)
+		}
+		pub, err := jwt.ParsePublicKey(alg, pubPEM)
+		if err != nil {
+			t.Fatalf("jwt.ParsePublicKey(%q) failed: %v", alg, err)
+		}
+
+		s, err := jwt.EncodeWithKey(tok, priv)
+		if err != nil {
+			t.Fatalf("jwt.EncodeWithKey with %q key failed: %v", alg, err)
+		}
+		got, valid, err := jwt.DecodeWithKey(s, pub)
+		if err != nil {
+			t.Fatalf("jwt.DecodeWithKey with %q public key failed: %v", alg, err)
+		}
+		if !valid || got.Subject != tok.Subject {
+			t.Errorf("jwt.DecodeWithKey with %q public key = (%#v, %v), want subject %q and valid token", alg, got, valid, tok.Subject)
+		}
+
+		if _, err := jwt.EncodeWithKey(tok, pub); err == nil {
+			t.Errorf("jwt.EncodeWithKey with %q public key expected to fail", alg)
+		}
+		if _, _, err := jwt.DecodeWithKey(s, jwt.NewHMACKey(pubPEM)); err == nil {
+			t.Errorf("jwt.DecodeWithKey of %q token with HMAC key expected to fail", alg)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package jwt
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// KeySet holds the keys of an issuer. Tokens are signed with the current key
+// and verified with whichever key of the set is named by their "kid" header.
+// Retired keys are kept in the set so that the tokens they signed remain
+// valid until they expire.
+//
+// To rotate keys without dropping sessions, Rotate to a new key and Remove
+// the retired one once the maximum token lifetime has passed.
+type KeySet struct {
+	mu      sync.RWMutex
+	current *Key
+	retired []*Key
+}
+
+// NewHMACKeySet returns a key set that signs tokens using HS256 and the
+// primary secret and also verifies tokens signed with any of the previous
+// secrets.
+func NewHMACKeySet(primary []byte, previous ...[]byte) *KeySet {
+	retired := make([]*Key, len(previous))
+	for i, secret := range previous {
+		retired[i] = NewHMACKey(secret)
+	}
+	return NewKeySet(NewHMACKey(primary), retired...)
+}
+
+// NewKeySet returns a key set that signs tokens with current and also
+// verifies tokens signed with any of the retired keys.
+func NewKeySet(current *Key, retired ...*Key) *KeySet {
+	return &KeySet{current: current, retired: retired}
+}
+
+// Current returns the key used for signing.
+func (ks *KeySet) Current() *Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.current
+}
+
+// Keys returns all the keys of the set starting with the current one.
+func (ks *KeySet) Keys() []*Key {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return append([]*Key{ks.current}, ks.retired...)
+}
+
+// Rotate makes k the current key and retires the previous one.
+func (ks *KeySet) Rotate(k *Key) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.retired = append([]*Key{ks.current}, ks.retired...)
+	ks.current = k
+}
+
+// Remove removes the retired key with the given ID. Tokens signed with the
+// removed key are no longer accepted. The current key cannot be removed.
+func (ks *KeySet) Remove(kid string) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	var keys []*Key
+	for _, k := range ks.retired {
+		if k.ID != kid {
+			keys = append(keys, k)
+		}
+	}
+	ks.retired = keys
+}
+
+// Encode encodes and signs a JWT token using the current key.
+func (ks *KeySet) Encode(t *Token) (string, error) {
+	return EncodeWithKey(t, ks.Current())
+}
+
+// Decode parses a token from a string format and returns a Token struct. The
+// token must be signed with one of the keys of the set.
+//
+// Tokens without a "kid" header were signed before keys were identified so
+// every key of the set is tried, starting with the current one.
+func (ks *KeySet) Decode(t string) (*Token, bool, error) {
+	return ks.DecodeWithLeeway(t, 0)
+}
+
+// DecodeWithLeeway is like Decode but allows for leeway of clock skew when
+// validating the expiration and the other time based claims.
+func (ks *KeySet) DecodeWithLeeway(t string, leeway time.Duration) (*Token, bool, error) {
+	if headerKID(t) != "" {
+		return decode(t, leeway, ks.lookup)
+	}
+	var err error
+	for _, k := range ks.Keys() {
+		var tok *Token
+		var valid bool
+		tok, valid, err = decodeWithKey(t, k, leeway)
+		if err == nil || !(IsKind(err, BadSignature) || IsKind(err, WrongAlgorithm)) {
+			// Other errors are about the token regardless of the key.
+			return tok, valid, err
+		}
+	}
+	return nil, false, err
+}
+
+// headerKID returns the "kid" header of a token without verifying it.
+func headerKID(t string) string {
+	token, _, err := new(jwt.Parser).ParseUnverified(t, &jwt.MapClaims{})
+	if err != nil {
+		return ""
+	}
+	kid, _ := token.Header["kid"].(string)
+	return kid
+}
+
+// lookup finds the key with the given "kid" header which must use alg.
+func (ks *KeySet) lookup(alg, kid string) (*Key, error) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	knownAlg := false
+	for _, k := range append([]*Key{ks.current}, ks.retired...) {
+		if k.ID == kid {
+			if k.Alg != alg {
+				return nil, errWrongAlgorithm
+			}
+			return k, nil
+		}
+		knownAlg = knownAlg || k.Alg == alg
+	}
+	if !knownAlg {
+		return nil, errWrongAlgorithm
+	}
+	return nil, &Error{Kind: BadSignature, Err: fmt.Errorf("unknown key %q", kid)}
+}
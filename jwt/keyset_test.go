@@ -0,0 +1,89 @@
+package jwt_test
+
+import (
+	"testing"
+	"time"
+
+	gojwt "github.com/dgrijalva/jwt-go"
+	"github.com/kusubooru/monban/jwt"
+)
+
+func TestKeySet_Rotate(t *testing.T) {
+	oldPEM, _ := generatePEM(t, jwt.ES256)
+	newPEM, _ := generatePEM(t, jwt.EdDSA)
+	oldKey, err := jwt.ParsePrivateKey(jwt.ES256, oldPEM)
+	if err != nil {
+		t.Fatal("jwt.ParsePrivateKey failed:", err)
+	}
+	newKey, err := jwt.ParsePrivateKey("", newPEM)
+	if err != nil {
+		t.Fatal("jwt.ParsePrivateKey failed:", err)
+	}
+	if newKey.Alg != jwt.EdDSA {
+		t.Errorf("jwt.ParsePrivateKey inferred alg %q, want %q", newKey.Alg, jwt.EdDSA)
+	}
+
+	now := time.Now()
+	tok := &jwt.Token{Subject: "1", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+	ks := jwt.NewKeySet(oldKey)
+	s, err := ks.Encode(tok)
+	if err != nil {
+		t.Fatal("KeySet.Encode failed:", err)
+	}
+
+	ks.Rotate(newKey)
+	if _, valid, err := ks.Decode(s); err != nil || !valid {
+		t.Errorf("KeySet.Decode of token signed with retired key = (%v, %v), want valid token", valid, err)
+	}
+	s2, err := ks.Encode(tok)
+	if err != nil {
+		t.Fatal("KeySet.Encode after rotation failed:", err)
+	}
+	if _, valid, err := jwt.DecodeWithKey(s2, newKey); err != nil || !valid {
+		t.Errorf("token after rotation is not signed with the new key: (%v, %v)", valid, err)
+	}
+	if got, want := len(ks.JWKS().Keys), 2; got != want {
+		t.Errorf("KeySet.JWKS() has %d keys, want %d", got, want)
+	}
+
+	ks.Remove(oldKey.ID)
+	if _, _, err := ks.Decode(s); err == nil {
+		t.Errorf("KeySet.Decode of token signed with removed key expected to fail")
+	}
+}
+
+func TestKeySet_JWKS_omitsHMAC(t *testing.T) {
+	ks := jwt.NewKeySet(jwt.NewHMACKey([]byte("secret")))
+	if got := len(ks.JWKS().Keys); got != 0 {
+		t.Errorf("KeySet.JWKS() of HMAC key set has %d keys, want 0", got)
+	}
+}
+
+func TestDecode_previousSecrets(t *testing.T) {
+	now := time.Now()
+	tok := &jwt.Token{Subject: "1", IssuedAt: now.Unix(), ExpiresAt: now.Add(time.Minute).Unix()}
+	oldSecret, newSecret := []byte("old"), []byte("new")
+
+	s, err := jwt.Encode(tok, oldSecret)
+	if err != nil {
+		t.Fatal("jwt.Encode failed:", err)
+	}
+	if _, valid, err := jwt.Decode(s, newSecret, oldSecret); err != nil || !valid {
+		t.Errorf("jwt.Decode with previous secret = (%v, %v), want valid token", valid, err)
+	}
+	if _, _, err := jwt.Decode(s, newSecret); err == nil {
+		t.Errorf("jwt.Decode without previous secret expected to fail")
+	}
+
+	// Tokens issued before keys were identified have no "kid" header.
+	legacy, err := gojwt.NewWithClaims(gojwt.SigningMethodHS256, gojwt.StandardClaims{
+		Subject:   "1",
+		ExpiresAt: tok.ExpiresAt,
+	}).SignedString(oldSecret)
+	if err != nil {
+		t.Fatal("signing legacy token failed:", err)
+	}
+	if _, valid, err := jwt.Decode(legacy, newSecret, oldSecret); err != nil || !valid {
+		t.Errorf("jwt.Decode of legacy token with previous secret = (%v, %v), want valid token", valid, err)
+	}
+}
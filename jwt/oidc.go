@@ -0,0 +1,29 @@
+package jwt
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"fmt"
+	"hash"
+)
+
+// AccessTokenHash returns the "at_hash" claim of an OpenID Connect ID token
+// that is issued along with accessToken and signed using alg. It is the
+// base64url encoded left half of the hash of the access token where the hash
+// function matches the one of alg.
+func AccessTokenHash(alg, accessToken string) (string, error) {
+	var h hash.Hash
+	switch alg {
+	case HS256, RS256, ES256:
+		h = sha256.New()
+	case EdDSA:
+		// Ed25519 uses SHA-512 internally.
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	h.Write([]byte(accessToken))
+	sum := h.Sum(nil)
+	return base64.RawURLEncoding.EncodeToString(sum[:len(sum)/2]), nil
+}
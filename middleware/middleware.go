@@ -0,0 +1,322 @@
+// Package middleware provides HTTP middleware that lets services verify the
+// access tokens issued by Monban.
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+// DefaultCSRFHeader is the request header that is expected to carry the CSRF
+// token of the access token. It is checked for the tokens read from a cookie
+// unless Verifier.CSRFHeader is set.
+const DefaultCSRFHeader = "X-CSRF-Token"
+
+// Verifier verifies the access tokens of incoming requests. Refresh and ID
+// tokens are rejected.
+type Verifier struct {
+	// Keys holds the keys that verify the token signatures.
+	Keys *jwt.KeySet
+	// Issuer, if not empty, must match the issuer of the tokens.
+	Issuer string
+	// Audience lists the audiences the service identifies as. Tokens must
+	// be intended for at least one of them. It is required unless
+	// AllowAnyAudience is set, otherwise every request is rejected.
+	Audience []string
+	// AllowAnyAudience disables the audience check when Audience is empty.
+	// It makes the service accept tokens that were issued for any other
+	// service, so it should only be used by services that are themselves
+	// the only audience of the keys.
+	AllowAnyAudience bool
+	// Leeway allows for clock skew when validating the expiration of the
+	// tokens.
+	Leeway time.Duration
+	// Cookie, if not empty, is the name of the cookie the token is read from
+	// when the request has no Authorization header.
+	Cookie string
+	// CSRFHeader, if not empty, is the request header that must match the
+	// CSRF claim of the token for requests that are not safe (GET, HEAD,
+	// OPTIONS). If empty, DefaultCSRFHeader is checked for the tokens read
+	// from the cookie only.
+	CSRFHeader string
+	// Denylist, if not nil, is consulted so that revoked tokens are rejected
+	// before they expire.
+	Denylist Denylist
+	// Opaque, if not nil, authenticates the tokens that are not JWTs, such
+	// as the personal access tokens of Monban. Their issuer and audience are
+	// checked like those of JWTs but they are not checked against the
+	// denylist or for CSRF as they are never sent in cookies.
+	Opaque Authenticator
+}
+
+// Authenticator returns the token that an opaque token stands for. It is
+// implemented by the AuthService of Monban and, for remote services, by the
+// Introspector of package client. Any error rejects the token.
+type Authenticator interface {
+	Authenticate(token string) (*jwt.Token, error)
+}
+
+// Denylist reports whether a token has been revoked. It is implemented by the
+// denylist stores of Monban.
+//
+// The bolt-backed denylist of package boltdb cannot be shared with a running
+// Monban server: bolt holds an exclusive lock on its database file so a
+// second process blocks until opening it times out. Services that run apart
+// from Monban should check the revocation of tokens through the introspection
+// endpoint instead.
+type Denylist interface {
+	IsDenied(t *jwt.Token) (bool, error)
+}
+
+// Error is written as the JSON response of rejected requests. Its fields
+// match the errors of the Monban API.
+type Error struct {
+	Message string `json:"message"`
+	Code    int    `json:"code"`
+	Type    string `json:"error,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func (e *Error) write(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	if e.Code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+	w.WriteHeader(e.Code)
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func unauthorized(message, typ string) *Error {
+	return &Error{Message: message, Code: http.StatusUnauthorized, Type: typ}
+}
+
+// Verify extracts the bearer token of r and verifies it. The returned error
+// is an *Error.
+func (v *Verifier) Verify(r *http.Request) (*jwt.Token, error) {
+	tok, _, err := v.verify(r)
+	if err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
+
+// verify is like Verify but also reports whether the token is opaque.
+func (v *Verifier) verify(r *http.Request) (*jwt.Token, bool, error) {
+	if len(v.Audience) == 0 && !v.AllowAnyAudience {
+		return nil, false, &Error{Message: "verifier has no audience", Code: http.StatusInternalServerError}
+	}
+	raw, fromCookie := v.extract(r)
+	if raw == "" {
+		return nil, false, unauthorized("expecting bearer token", "token_missing")
+	}
+	opaque := v.Opaque != nil && !isJWT(raw)
+	var tok *jwt.Token
+	if opaque {
+		var err error
+		tok, err = v.Opaque.Authenticate(raw)
+		if err != nil || tok == nil {
+			return nil, false, unauthorized("invalid token", "invalid_token")
+		}
+	} else {
+		var valid bool
+		var err error
+		tok, valid, err = v.Keys.DecodeWithLeeway(raw, v.Leeway)
+		if err != nil {
+			if e, ok := err.(*jwt.Error); ok {
+				return nil, false, unauthorized("invalid token", e.Kind.String())
+			}
+			return nil, false, unauthorized("invalid token", "invalid_token")
+		}
+		if !valid {
+			return nil, false, unauthorized("invalid token", "invalid_token")
+		}
+		// Refresh and ID tokens are signed with the same keys and must
+		// not be mistaken for access tokens.
+		if tok.Type != jwt.TypeAccess {
+			return nil, false, unauthorized("not an access token", "invalid_token")
+		}
+	}
+	if v.Issuer != "" && tok.Issuer != v.Issuer {
+		return nil, false, unauthorized("invalid token issuer", "invalid_token")
+	}
+	if len(v.Audience) != 0 {
+		if err := jwt.VerifyAudience(tok, v.Audience...); err != nil {
+			return nil, false, unauthorized("invalid token audience", jwt.InvalidAudience.String())
+		}
+	}
+	if opaque {
+		return tok, true, nil
+	}
+	if v.Denylist != nil {
+		denied, err := v.Denylist.IsDenied(tok)
+		if err != nil {
+			return nil, false, &Error{Message: "checking token revocation failed", Code: http.StatusInternalServerError}
+		}
+		if denied {
+			return nil, false, unauthorized("token revoked", "invalid_token")
+		}
+	}
+	csrfHeader := v.CSRFHeader
+	if csrfHeader == "" && fromCookie {
+		csrfHeader = DefaultCSRFHeader
+	}
+	if csrfHeader != "" && !safeMethod(r.Method) {
+		h := r.Header.Get(csrfHeader)
+		if tok.CSRF == "" || subtle.ConstantTimeCompare([]byte(h), []byte(tok.CSRF)) != 1 {
+			return nil, false, &Error{Message: "CSRF token mismatch", Code: http.StatusForbidden, Type: "csrf_mismatch"}
+		}
+	}
+	return tok, false, nil
+}
+
+// isJWT reports whether raw has the three parts of a JWT.
+func isJWT(raw string) bool {
+	return strings.Count(raw, ".") == 2
+}
+
+// extract returns the raw token from the Authorization header or from the
+// cookie and reports whether it was read from the cookie.
+func (v *Verifier) extract(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	if h := r.Header.Get("Authorization"); h != "" {
+		if len(h) > len(prefix) && strings.EqualFold(h[:len(prefix)], prefix) {
+			return strings.TrimSpace(h[len(prefix):]), false
+		}
+		return "", false
+	}
+	if v.Cookie != "" {
+		if c, err := r.Cookie(v.Cookie); err == nil {
+			return c.Value, true
+		}
+	}
+	return "", false
+}
+
+func safeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return false
+}
+
+// Handler returns a handler that verifies the token of each request before
+// passing it to h with the token stored in the request context. Requests with
+// invalid tokens are rejected.
+func (v *Verifier) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tok, opaque, err := v.verify(r)
+		if err != nil {
+			err.(*Error).write(w)
+			return
+		}
+		ctx := NewContext(r.Context(), tok)
+		if opaque {
+			ctx = context.WithValue(ctx, opaqueKey, true)
+		}
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+type contextKey int
+
+const (
+	tokenKey contextKey = iota
+	opaqueKey
+)
+
+// NewContext returns a new context that carries token t.
+func NewContext(ctx context.Context, t *jwt.Token) context.Context {
+	return context.WithValue(ctx, tokenKey, t)
+}
+
+// FromContext returns the token stored in ctx, if any.
+func FromContext(ctx context.Context) (*jwt.Token, bool) {
+	t, ok := ctx.Value(tokenKey).(*jwt.Token)
+	return t, ok
+}
+
+// Token returns the verified token of a request that passed through
+// Verifier.Handler or nil.
+func Token(r *http.Request) *jwt.Token {
+	t, _ := FromContext(r.Context())
+	return t
+}
+
+// Require returns a middleware that only lets through requests whose token
+// satisfies allow. It must be used after Verifier.Handler.
+func Require(allow func(t *jwt.Token) bool) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			t, ok := FromContext(r.Context())
+			if !ok {
+				unauthorized("expecting bearer token", "token_missing").write(w)
+				return
+			}
+			if !allow(t) {
+				e := &Error{Message: http.StatusText(http.StatusForbidden), Code: http.StatusForbidden, Type: "forbidden"}
+				e.write(w)
+				return
+			}
+			h.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireAdmin only lets through requests of admins. Like the admin endpoints
+// of Monban, it rejects opaque tokens, such as personal access tokens, as they
+// are long lived, and the tokens issued to clients, even when they belong to
+// an admin.
+func RequireAdmin(h http.Handler) http.Handler {
+	return Require(func(t *jwt.Token) bool { return t.Admin })(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opaque, _ := r.Context().Value(opaqueKey).(bool); opaque {
+			e := &Error{Message: "opaque tokens cannot be used for admin endpoints", Code: http.StatusForbidden, Type: "forbidden"}
+			e.write(w)
+			return
+		}
+		if _, ok := Token(r).Claims[claimClientID]; ok {
+			e := &Error{Message: "tokens issued to clients cannot be used for admin endpoints", Code: http.StatusForbidden, Type: "forbidden"}
+			e.write(w)
+			return
+		}
+		h.ServeHTTP(w, r)
+	}))
+}
+
+// claimClientID is the claim of the tokens that Monban issues to clients.
+const claimClientID = "client_id"
+
+// RequireScope returns a middleware that only lets through requests whose
+// token grants all of the given scopes.
+func RequireScope(scopes ...string) func(http.Handler) http.Handler {
+	return Require(func(t *jwt.Token) bool {
+		for _, s := range scopes {
+			if !t.HasScope(s) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// RequireClass returns a middleware that only lets through requests of users
+// that belong to one of the given classes.
+func RequireClass(classes ...string) func(http.Handler) http.Handler {
+	return Require(func(t *jwt.Token) bool {
+		for _, c := range classes {
+			if t.Class == c {
+				return true
+			}
+		}
+		return false
+	})
+}
@@ -0,0 +1,309 @@
+package middleware_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+	"github.com/kusubooru/monban/middleware"
+)
+
+// denyIDs denies the tokens with the given IDs.
+type denyIDs []string
+
+func (d denyIDs) IsDenied(t *jwt.Token) (bool, error) {
+	for _, id := range d {
+		if t.ID == id {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestVerifier_Handler(t *testing.T) {
+	keys := jwt.NewHMACKeySet([]byte("secret"))
+	v := &middleware.Verifier{
+		Keys:       keys,
+		Issuer:     "monban",
+		Audience:   []string{"booru"},
+		Leeway:     time.Minute,
+		Cookie:     "access_token",
+		CSRFHeader: middleware.DefaultCSRFHeader,
+		Denylist:   denyIDs{"revoked"},
+	}
+	now := time.Now()
+	encode := func(tok *jwt.Token) string {
+		s, err := keys.Encode(tok)
+		if err != nil {
+			t.Fatal("KeySet.Encode failed:", err)
+		}
+		return s
+	}
+	token := func(mod func(*jwt.Token)) string {
+		tok := &jwt.Token{
+			Type:      jwt.TypeAccess,
+			Subject:   "2",
+			Issuer:    "monban",
+			Audience:  []string{"booru"},
+			Class:     "user",
+			CSRF:      "csrf",
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(time.Minute).Unix(),
+		}
+		if mod != nil {
+			mod(tok)
+		}
+		return encode(tok)
+	}
+
+	h := v.Handler(middleware.RequireClass("user")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tok := middleware.Token(r); tok == nil || tok.Subject != "2" {
+			t.Errorf("middleware.Token(r) = %#v, want token with subject %q", tok, "2")
+		}
+	})))
+
+	tests := []struct {
+		name   string
+		method string
+		header string
+		cookie string
+		csrf   string
+		want   int
+	}{
+		{"header", "GET", "Bearer " + token(nil), "", "", http.StatusOK},
+		{"cookie", "GET", "", token(nil), "", http.StatusOK},
+		{"missing", "GET", "", "", "", http.StatusUnauthorized},
+		{"skew", "GET", "Bearer " + token(func(t *jwt.Token) { t.ExpiresAt = now.Add(-30 * time.Second).Unix() }), "", "", http.StatusOK},
+		{"expired", "GET", "Bearer " + token(func(t *jwt.Token) { t.ExpiresAt = now.Add(-time.Hour).Unix() }), "", "", http.StatusUnauthorized},
+		{"issuer", "GET", "Bearer " + token(func(t *jwt.Token) { t.Issuer = "other" }), "", "", http.StatusUnauthorized},
+		{"revoked", "GET", "Bearer " + token(func(t *jwt.Token) { t.ID = "revoked" }), "", "", http.StatusUnauthorized},
+		{"audience", "GET", "Bearer " + token(func(t *jwt.Token) { t.Audience = []string{"wiki"} }), "", "", http.StatusUnauthorized},
+		{"refresh token", "GET", "Bearer " + token(func(t *jwt.Token) { t.Type = jwt.TypeRefresh }), "", "", http.StatusUnauthorized},
+		{"id token", "GET", "Bearer " + token(func(t *jwt.Token) { t.Type = jwt.TypeID }), "", "", http.StatusUnauthorized},
+		{"untyped", "GET", "Bearer " + token(func(t *jwt.Token) { t.Type = "" }), "", "", http.StatusUnauthorized},
+		{"csrf", "POST", "Bearer " + token(nil), "", "csrf", http.StatusOK},
+		{"csrf mismatch", "POST", "Bearer " + token(nil), "", "wrong", http.StatusForbidden},
+		{"class", "GET", "Bearer " + token(func(t *jwt.Token) { t.Class = "anonymous" }), "", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, "/", nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "access_token", Value: tt.cookie})
+		}
+		if tt.csrf != "" {
+			r.Header.Set(middleware.DefaultCSRFHeader, tt.csrf)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Code; got != tt.want {
+			t.Errorf("%s: status = %d, want %d (body: %s)", tt.name, got, tt.want, w.Body)
+		}
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	h := middleware.RequireScope("read", "write")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	v := &middleware.Verifier{Keys: jwt.NewHMACKeySet([]byte("secret")), Audience: []string{"booru"}}
+
+	tests := []struct {
+		scope []string
+		want  int
+	}{
+		{[]string{"read", "write"}, http.StatusOK},
+		{[]string{"write", "read", "admin"}, http.StatusOK},
+		{[]string{"read"}, http.StatusForbidden},
+		{nil, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		tok := &jwt.Token{
+			Type:      jwt.TypeAccess,
+			Subject:   "2",
+			Audience:  []string{"booru"},
+			Scope:     tt.scope,
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+		}
+		s, err := v.Keys.Encode(tok)
+		if err != nil {
+			t.Fatal("KeySet.Encode failed:", err)
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+s)
+		w := httptest.NewRecorder()
+		v.Handler(h).ServeHTTP(w, r)
+		if got := w.Code; got != tt.want {
+			t.Errorf("scope %q: status = %d, want %d (body: %s)", tt.scope, got, tt.want, w.Body)
+		}
+	}
+}
+
+// opaqueTokens authenticates the opaque tokens in the map.
+type opaqueTokens map[string]*jwt.Token
+
+func (o opaqueTokens) Authenticate(token string) (*jwt.Token, error) {
+	t, ok := o[token]
+	if !ok {
+		return nil, errors.New("unknown token")
+	}
+	return t, nil
+}
+
+func TestVerifier_Opaque(t *testing.T) {
+	v := &middleware.Verifier{
+		Keys:       jwt.NewHMACKeySet([]byte("secret")),
+		Issuer:     "monban",
+		Audience:   []string{"booru"},
+		CSRFHeader: middleware.DefaultCSRFHeader,
+		Opaque: opaqueTokens{
+			"mbp_valid": {Subject: "2", Issuer: "monban", Audience: []string{"booru"}},
+			"mbp_wiki":  {Subject: "2", Issuer: "monban", Audience: []string{"wiki"}},
+		},
+	}
+	h := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{"mbp_valid", http.StatusOK},
+		{"mbp_unknown", http.StatusUnauthorized},
+		{"mbp_wiki", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		// Opaque tokens are not checked for CSRF.
+		r := httptest.NewRequest("POST", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Code; got != tt.want {
+			t.Errorf("%s: status = %d, want %d (body: %s)", tt.token, got, tt.want, w.Body)
+		}
+	}
+}
+
+func TestVerifier_defaultCSRF(t *testing.T) {
+	keys := jwt.NewHMACKeySet([]byte("secret"))
+	v := &middleware.Verifier{Keys: keys, Cookie: "access_token", AllowAnyAudience: true}
+	h := v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	s, err := keys.Encode(&jwt.Token{
+		Type:      jwt.TypeAccess,
+		Subject:   "2",
+		CSRF:      "csrf",
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal("KeySet.Encode failed:", err)
+	}
+
+	tests := []struct {
+		name   string
+		header bool
+		csrf   string
+		want   int
+	}{
+		{"cookie", false, "csrf", http.StatusOK},
+		{"cookie without csrf", false, "", http.StatusForbidden},
+		{"cookie csrf mismatch", false, "wrong", http.StatusForbidden},
+		{"header without csrf", true, "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/", nil)
+		if tt.header {
+			r.Header.Set("Authorization", "Bearer "+s)
+		} else {
+			r.AddCookie(&http.Cookie{Name: "access_token", Value: s})
+		}
+		if tt.csrf != "" {
+			r.Header.Set(middleware.DefaultCSRFHeader, tt.csrf)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Code; got != tt.want {
+			t.Errorf("%s: status = %d, want %d (body: %s)", tt.name, got, tt.want, w.Body)
+		}
+	}
+}
+
+func TestVerifier_audienceRequired(t *testing.T) {
+	keys := jwt.NewHMACKeySet([]byte("secret"))
+	s, err := keys.Encode(&jwt.Token{
+		Type:      jwt.TypeAccess,
+		Subject:   "2",
+		Audience:  []string{"wiki"},
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	})
+	if err != nil {
+		t.Fatal("KeySet.Encode failed:", err)
+	}
+
+	tests := []struct {
+		name string
+		v    *middleware.Verifier
+		want int
+	}{
+		{"no audience", &middleware.Verifier{Keys: keys}, http.StatusInternalServerError},
+		{"other audience", &middleware.Verifier{Keys: keys, Audience: []string{"booru"}}, http.StatusUnauthorized},
+		{"any audience", &middleware.Verifier{Keys: keys, AllowAnyAudience: true}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		h := tt.v.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+s)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Code; got != tt.want {
+			t.Errorf("%s: status = %d, want %d (body: %s)", tt.name, got, tt.want, w.Body)
+		}
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	keys := jwt.NewHMACKeySet([]byte("secret"))
+	v := &middleware.Verifier{
+		Keys:     keys,
+		Audience: []string{"booru"},
+		Opaque: opaqueTokens{
+			"mbp_admin": {Subject: "2", Audience: []string{"booru"}, Admin: true},
+		},
+	}
+	h := v.Handler(middleware.RequireAdmin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	token := func(admin bool, claims map[string]interface{}) string {
+		s, err := keys.Encode(&jwt.Token{
+			Type:      jwt.TypeAccess,
+			Subject:   "2",
+			Audience:  []string{"booru"},
+			Admin:     admin,
+			ExpiresAt: time.Now().Add(time.Minute).Unix(),
+			Claims:    claims,
+		})
+		if err != nil {
+			t.Fatal("KeySet.Encode failed:", err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"admin", token(true, nil), http.StatusOK},
+		{"user", token(false, nil), http.StatusForbidden},
+		{"client admin", token(true, map[string]interface{}{"client_id": "wiki"}), http.StatusForbidden},
+		{"opaque admin", "mbp_admin", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if got := w.Code; got != tt.want {
+			t.Errorf("%s: status = %d, want %d (body: %s)", tt.name, got, tt.want, w.Body)
+		}
+	}
+}
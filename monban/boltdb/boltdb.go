@@ -1,6 +1,8 @@
 package boltdb
 
 import (
+	"encoding/binary"
+	"fmt"
 	"log"
 	"time"
 
@@ -9,8 +11,89 @@ import (
 
 const (
 	whitelistBucket = "whitelist"
+	// subjectsBucket indexes the whitelisted tokens by their subject. The
+	// keys are laid out as subject + 0x00 + token ID and the values are
+	// empty.
+	subjectsBucket = "subjects"
+	// usedBucket keeps the refresh tokens that have already been exchanged
+	// for new ones. It has the same layout as the whitelist bucket so that
+	// reused tokens can be detected until they expire.
+	usedBucket = "used"
+	// familiesBucket indexes the whitelisted and used tokens by their
+	// family. The keys are laid out as family + 0x00 + token ID and the
+	// values are empty.
+	familiesBucket = "families"
+	// sessionsBucket keeps the metadata of the sessions keyed by the token
+	// family.
+	sessionsBucket = "sessions"
+	// deniedBucket keeps the IDs of the revoked access tokens. The values
+	// are the 8-byte big endian time the tokens expire.
+	deniedBucket = "denied"
+	// generationsBucket keeps the revocation generation of the subjects
+	// whose access tokens have all been revoked. The values are the 8-byte
+	// big endian generation. They are never reaped as the generations must
+	// only ever increase.
+	generationsBucket = "generations"
+	// codesBucket keeps the OAuth authorization codes keyed by their hash.
+	// The values are laid out as the 8-byte big endian time the code
+	// expires followed by the code.
+	codesBucket = "codes"
+	// devicesBucket keeps the device codes of the device authorization
+	// grant keyed by their hash. The values are laid out as the 8-byte big
+	// endian time the device code expires followed by the device code.
+	devicesBucket = "devices"
+	// userCodesBucket indexes the device codes by their user code. The
+	// values are laid out as the 8-byte big endian time the device code
+	// expires followed by its hash.
+	userCodesBucket = "user_codes"
+	// resetsBucket keeps the password reset tokens keyed by their hash.
+	// The values are laid out as the 8-byte big endian time the token
+	// expires followed by the token.
+	resetsBucket = "resets"
 )
 
+var buckets = []string{
+	whitelistBucket,
+	subjectsBucket,
+	usedBucket,
+	familiesBucket,
+	sessionsBucket,
+	deniedBucket,
+	generationsBucket,
+	codesBucket,
+	devicesBucket,
+	userCodesBucket,
+	resetsBucket,
+}
+
+// DB is an open bolt database file. It holds the buckets of all the stores of
+// this package which share it as a bolt database file can only be opened by
+// one process at a time.
+type DB struct {
+	*bolt.DB
+}
+
+// Open opens the bolt database file and creates the buckets of all the
+// stores. The bolt database file will be created if it does not exist.
+func Open(boltFile string) *DB {
+	return &DB{openBolt(boltFile)}
+}
+
+// Whitelist returns the implementation for monban.Whitelist.
+func (db *DB) Whitelist() *Whitelist { return &Whitelist{db.DB} }
+
+// Denylist returns the implementation for monban.Denylist.
+func (db *DB) Denylist() *Denylist { return &Denylist{db.DB} }
+
+// CodeStore returns the implementation for monban.CodeStore.
+func (db *DB) CodeStore() *CodeStore { return &CodeStore{db.DB} }
+
+// DeviceStore returns the implementation for monban.DeviceStore.
+func (db *DB) DeviceStore() *DeviceStore { return &DeviceStore{db.DB} }
+
+// ResetStore returns the implementation for monban.ResetStore.
+func (db *DB) ResetStore() *ResetStore { return &ResetStore{db.DB} }
+
 type Whitelist struct {
 	*bolt.DB
 }
@@ -19,6 +102,27 @@ func (db *Whitelist) Close() error {
 	return db.DB.Close()
 }
 
+// Denylist keeps the revoked access tokens and the revocation generations of
+// the subjects.
+type Denylist struct {
+	*bolt.DB
+}
+
+// CodeStore keeps the OAuth authorization codes.
+type CodeStore struct {
+	*bolt.DB
+}
+
+// DeviceStore keeps the device codes of the device authorization grant.
+type DeviceStore struct {
+	*bolt.DB
+}
+
+// ResetStore keeps the password reset tokens.
+type ResetStore struct {
+	*bolt.DB
+}
+
 // openBolt creates and opens a bolt database at the given path. If the file does
 // not exist then it will be created automatically. After opening it creates
 // all the needed buckets.
@@ -28,8 +132,12 @@ func openBolt(file string) *bolt.DB {
 		log.Fatalln("bolt open failed:", err)
 	}
 	err = db.Update(func(tx *bolt.Tx) error {
-		_, err = tx.CreateBucketIfNotExists([]byte(whitelistBucket))
-		return err
+		for _, b := range buckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(b)); err != nil {
+				return err
+			}
+		}
+		return nil
 	})
 	if err != nil {
 		log.Fatalln("bolt bucket creation failed:", err)
@@ -39,8 +147,62 @@ func openBolt(file string) *bolt.DB {
 
 // NewWhitelist opens the bolt database file and returns an implementation for
 // monban.Whitelist. The bolt database file will be created if it does not
-// exist.
+// exist. Use Open to get the other stores of the same file.
 func NewWhitelist(boltFile string) *Whitelist {
-	db := openBolt(boltFile)
-	return &Whitelist{db}
+	return Open(boltFile).Whitelist()
+}
+
+// ReapExpired removes, every interval, the entries of the denylist once the
+// tokens they deny have expired along with the expired authorization and
+// device codes, password reset tokens and the sessions that have no token
+// left. Errors are logged and do not stop the reaping. Like Reap, it is meant
+// to be called only once and on a separate goroutine at the start of the
+// program.
+func (db *DB) ReapExpired(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for now := range t.C {
+		if err := db.reapExpired(now); err != nil {
+			log.Println("reaping expired entries failed:", err)
+		}
+		if err := db.Whitelist().reapSessions(); err != nil {
+			log.Println("reaping sessions failed:", err)
+		}
+	}
+}
+
+// reapExpired removes the entries whose expiration time, stored as the first
+// 8 bytes of their value, is before now.
+func (db *DB) reapExpired(now time.Time) error {
+	for _, bucket := range []string{deniedBucket, codesBucket, devicesBucket, userCodesBucket, resetsBucket} {
+		err := db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket([]byte(bucket))
+			// Collect the keys first as deleting while iterating with a
+			// cursor may skip keys.
+			var expired [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				// The first 8 bytes of the value are the expiration time.
+				if int64(binary.BigEndian.Uint64(v)) < now.Unix() {
+					expired = append(expired, k)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if len(expired) == 0 {
+				return errNothingToReap
+			}
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return fmt.Errorf("delete: %s", err)
+				}
+			}
+			return nil
+		})
+		if err != nil && err != errNothingToReap {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,97 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/kusubooru/monban/monban"
+)
+
+// PutCode stores an authorization code until it expires.
+func (db *CodeStore) PutCode(c *monban.AuthCode) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putCode(tx, c)
+	})
+}
+
+func putCode(tx *bolt.Tx, c *monban.AuthCode) error {
+	if c.ExpiresAt < 0 {
+		return fmt.Errorf("code has negative expiration time")
+	}
+	buf := bytes.Buffer{}
+	// Write the expiration time as the first 8 bytes of the value so that
+	// expired codes are reaped along with the denylist.
+	buf.Write(itob(c.ExpiresAt))
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return fmt.Errorf("could not encode code: %v", err)
+	}
+	if err := tx.Bucket([]byte(codesBucket)).Put([]byte(c.Code), buf.Bytes()); err != nil {
+		return fmt.Errorf("could not put code: %v", err)
+	}
+	return nil
+}
+
+// GetCode returns an authorization code, used or not, without changing it. It
+// returns monban.ErrNotFound if the code does not exist.
+func (db *CodeStore) GetCode(code string) (*monban.AuthCode, error) {
+	var c *monban.AuthCode
+	err := db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(codesBucket)).Get([]byte(code))
+		if value == nil {
+			return monban.ErrNotFound
+		}
+		var err error
+		c, err = decodeCode(value)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// UseCode marks an authorization code as used by a token family and returns
+// it. The code is kept until it expires. If the code has already been used,
+// UseCode returns it, with the family of its first use, along with
+// monban.ErrCodeReused. It returns monban.ErrNotFound if the code does not
+// exist.
+func (db *CodeStore) UseCode(code, family string) (*monban.AuthCode, error) {
+	var c *monban.AuthCode
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(codesBucket))
+		value := b.Get([]byte(code))
+		if value == nil {
+			return monban.ErrNotFound
+		}
+		var err error
+		c, err = decodeCode(value)
+		if err != nil {
+			return err
+		}
+		if c.Used {
+			return monban.ErrCodeReused
+		}
+		c.Used = true
+		c.Family = family
+		return putCode(tx, c)
+	})
+	if err == monban.ErrCodeReused {
+		return c, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// decodeCode decodes an authorization code stored in the codes bucket.
+func decodeCode(value []byte) (*monban.AuthCode, error) {
+	// Skip the expiration time.
+	c := new(monban.AuthCode)
+	if err := gob.NewDecoder(bytes.NewReader(value[8:])).Decode(c); err != nil {
+		return nil, fmt.Errorf("could not decode code: %v", err)
+	}
+	return c, nil
+}
@@ -0,0 +1,71 @@
+package boltdb
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/kusubooru/monban/jwt"
+)
+
+// DenyToken adds the ID of an access token to the denylist. The entry is
+// kept until expiresAt, the time the token expires.
+func (db *Denylist) DenyToken(tokenID string, expiresAt int64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if expiresAt < 0 {
+			return fmt.Errorf("token has negative expiration time")
+		}
+		if err := tx.Bucket([]byte(deniedBucket)).Put([]byte(tokenID), itob(expiresAt)); err != nil {
+			return fmt.Errorf("could not put denied token: %v", err)
+		}
+		return nil
+	})
+}
+
+// DenySubject denies all the access tokens that have been issued to a subject
+// by moving it to the next generation.
+func (db *Denylist) DenySubject(subject string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(generationsBucket))
+		gen := btoi(b.Get([]byte(subject))) + 1
+		if err := b.Put([]byte(subject), itob(gen)); err != nil {
+			return fmt.Errorf("could not put subject generation: %v", err)
+		}
+		return nil
+	})
+}
+
+// SubjectGeneration returns the current generation of a subject which is zero
+// if its tokens have never been revoked.
+func (db *Denylist) SubjectGeneration(subject string) (int64, error) {
+	var gen int64
+	err := db.View(func(tx *bolt.Tx) error {
+		gen = btoi(tx.Bucket([]byte(generationsBucket)).Get([]byte(subject)))
+		return nil
+	})
+	return gen, err
+}
+
+// IsDenied reports whether access token t has been denied either by its ID
+// or because it belongs to an earlier generation of its subject.
+func (db *Denylist) IsDenied(t *jwt.Token) (bool, error) {
+	var denied bool
+	err := db.View(func(tx *bolt.Tx) error {
+		if t.ID != "" && tx.Bucket([]byte(deniedBucket)).Get([]byte(t.ID)) != nil {
+			denied = true
+			return nil
+		}
+		gen := btoi(tx.Bucket([]byte(generationsBucket)).Get([]byte(t.Subject)))
+		denied = t.Generation < gen
+		return nil
+	})
+	return denied, err
+}
+
+// btoi decodes an 8-byte big endian value. A missing value is zero.
+func btoi(b []byte) int64 {
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
@@ -0,0 +1,131 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/kusubooru/monban/monban"
+)
+
+// PutDeviceCode stores a device code until it expires and indexes it by its
+// user code.
+func (db *DeviceStore) PutDeviceCode(d *monban.DeviceCode) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if d.ExpiresAt < 0 {
+			return fmt.Errorf("device code has negative expiration time")
+		}
+		ub := tx.Bucket([]byte(userCodesBucket))
+		if ub.Get([]byte(d.UserCode)) != nil {
+			return fmt.Errorf("user code already exists")
+		}
+		if err := putDeviceCode(tx, d); err != nil {
+			return err
+		}
+		// Write the expiration time as the first 8 bytes of the index too
+		// so that it is reaped along with the device code.
+		value := append(itob(d.ExpiresAt), d.Code...)
+		if err := ub.Put([]byte(d.UserCode), value); err != nil {
+			return fmt.Errorf("could not put user code: %v", err)
+		}
+		return nil
+	})
+}
+
+// GetDeviceCode returns the device code with the given user code.
+func (db *DeviceStore) GetDeviceCode(userCode string) (*monban.DeviceCode, error) {
+	var d *monban.DeviceCode
+	err := db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(userCodesBucket)).Get([]byte(userCode))
+		if value == nil {
+			return monban.ErrNotFound
+		}
+		var err error
+		d, err = getDeviceCode(tx, value[8:])
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// UpdateDeviceCode replaces a device code. It returns monban.ErrNotFound if
+// the device code has expired or has already been polled for tokens.
+func (db *DeviceStore) UpdateDeviceCode(d *monban.DeviceCode) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(devicesBucket)).Get([]byte(d.Code)) == nil {
+			return monban.ErrNotFound
+		}
+		return putDeviceCode(tx, d)
+	})
+}
+
+// PollDeviceCode records the time a device code was polled at and returns the
+// device code as it was before. Device codes that have been approved or denied
+// are removed.
+func (db *DeviceStore) PollDeviceCode(code string, now int64) (*monban.DeviceCode, error) {
+	var d *monban.DeviceCode
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		d, err = getDeviceCode(tx, []byte(code))
+		if err != nil {
+			return err
+		}
+		if d.Status == monban.DevicePending {
+			polled := *d
+			polled.LastPoll = now
+			return putDeviceCode(tx, &polled)
+		}
+		if err := tx.Bucket([]byte(devicesBucket)).Delete([]byte(code)); err != nil {
+			return fmt.Errorf("could not delete device code: %v", err)
+		}
+		if err := tx.Bucket([]byte(userCodesBucket)).Delete([]byte(d.UserCode)); err != nil {
+			return fmt.Errorf("could not delete user code: %v", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// SlowDownDeviceCode adds by to the interval of a device code.
+func (db *DeviceStore) SlowDownDeviceCode(code string, by int64) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		d, err := getDeviceCode(tx, []byte(code))
+		if err != nil {
+			return err
+		}
+		d.Interval += by
+		return putDeviceCode(tx, d)
+	})
+}
+
+func putDeviceCode(tx *bolt.Tx, d *monban.DeviceCode) error {
+	buf := bytes.Buffer{}
+	// Write the expiration time as the first 8 bytes of the value so that
+	// expired device codes are reaped along with the denylist.
+	buf.Write(itob(d.ExpiresAt))
+	if err := gob.NewEncoder(&buf).Encode(d); err != nil {
+		return fmt.Errorf("could not encode device code: %v", err)
+	}
+	if err := tx.Bucket([]byte(devicesBucket)).Put([]byte(d.Code), buf.Bytes()); err != nil {
+		return fmt.Errorf("could not put device code: %v", err)
+	}
+	return nil
+}
+
+func getDeviceCode(tx *bolt.Tx, code []byte) (*monban.DeviceCode, error) {
+	value := tx.Bucket([]byte(devicesBucket)).Get(code)
+	if value == nil {
+		return nil, monban.ErrNotFound
+	}
+	d := new(monban.DeviceCode)
+	if err := gob.NewDecoder(bytes.NewReader(value[8:])).Decode(d); err != nil {
+		return nil, fmt.Errorf("could not decode device code: %v", err)
+	}
+	return d, nil
+}
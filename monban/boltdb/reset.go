@@ -0,0 +1,83 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+
+	"github.com/boltdb/bolt"
+	"github.com/kusubooru/monban/monban"
+)
+
+// PutResetToken stores a password reset token until it expires.
+func (db *ResetStore) PutResetToken(t *monban.ResetToken) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if t.ExpiresAt < 0 {
+			return fmt.Errorf("reset token has negative expiration time")
+		}
+		buf := bytes.Buffer{}
+		// Write the expiration time as the first 8 bytes of the value so
+		// that expired reset tokens are reaped along with the denylist.
+		buf.Write(itob(t.ExpiresAt))
+		if err := gob.NewEncoder(&buf).Encode(t); err != nil {
+			return fmt.Errorf("could not encode reset token: %v", err)
+		}
+		if err := tx.Bucket([]byte(resetsBucket)).Put([]byte(t.Hash), buf.Bytes()); err != nil {
+			return fmt.Errorf("could not put reset token: %v", err)
+		}
+		return nil
+	})
+}
+
+// UseResetToken removes a password reset token along with the other reset
+// tokens of the same user and returns it. It returns monban.ErrNotFound if the
+// token does not exist or has already been used.
+func (db *ResetStore) UseResetToken(hash string) (*monban.ResetToken, error) {
+	var t *monban.ResetToken
+	err := db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(resetsBucket))
+		value := b.Get([]byte(hash))
+		if value == nil {
+			return monban.ErrNotFound
+		}
+		var err error
+		t, err = decodeResetToken(value)
+		if err != nil {
+			return err
+		}
+		// Collect the keys first as deleting while iterating with a
+		// cursor may skip keys.
+		var used [][]byte
+		err = b.ForEach(func(k, v []byte) error {
+			other, err := decodeResetToken(v)
+			if err != nil {
+				return err
+			}
+			if other.UserID == t.UserID {
+				used = append(used, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, k := range used {
+			if err := b.Delete(k); err != nil {
+				return fmt.Errorf("could not delete reset token: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func decodeResetToken(value []byte) (*monban.ResetToken, error) {
+	t := new(monban.ResetToken)
+	if err := gob.NewDecoder(bytes.NewReader(value[8:])).Decode(t); err != nil {
+		return nil, fmt.Errorf("could not decode reset token: %v", err)
+	}
+	return t, nil
+}
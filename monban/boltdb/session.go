@@ -0,0 +1,138 @@
+package boltdb
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/kusubooru/monban/monban"
+)
+
+// PutSession stores the metadata of a session.
+func (db *Whitelist) PutSession(s *monban.Session) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putSession(tx, s)
+	})
+}
+
+func putSession(tx *bolt.Tx, s *monban.Session) error {
+	buf := bytes.Buffer{}
+	if err := gob.NewEncoder(&buf).Encode(s); err != nil {
+		return fmt.Errorf("could not encode session: %v", err)
+	}
+	if err := tx.Bucket([]byte(sessionsBucket)).Put([]byte(s.ID), buf.Bytes()); err != nil {
+		return fmt.Errorf("could not put session: %v", err)
+	}
+	return nil
+}
+
+// GetSession returns the metadata of a session or monban.ErrNotFound if the
+// session does not exist.
+func (db *Whitelist) GetSession(id string) (*monban.Session, error) {
+	var s *monban.Session
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		s, err = getSession(tx, id)
+		return err
+	})
+	return s, err
+}
+
+// ListUserTokens returns the sessions of all the whitelisted tokens of a
+// subject.
+func (db *Whitelist) ListUserTokens(subject string) ([]*monban.Session, error) {
+	var sessions []*monban.Session
+	err := db.View(func(tx *bolt.Tx) error {
+		wb := tx.Bucket([]byte(whitelistBucket))
+		for _, id := range indexed(tx, subjectsBucket, subject) {
+			value := wb.Get([]byte(id))
+			if value == nil {
+				continue
+			}
+			tok, err := decodeToken(value)
+			if err != nil {
+				return err
+			}
+			s, err := getSession(tx, tok.Family)
+			switch err {
+			case monban.ErrNotFound:
+				// Tokens issued before sessions were introduced have no
+				// metadata so we describe them with what the token knows.
+				issuedAt := time.Unix(tok.IssuedAt, 0)
+				s = &monban.Session{
+					ID:          tok.Family,
+					Subject:     tok.Subject,
+					Created:     issuedAt,
+					LastRefresh: issuedAt,
+				}
+			case nil:
+			default:
+				return err
+			}
+			sessions = append(sessions, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func getSession(tx *bolt.Tx, id string) (*monban.Session, error) {
+	value := tx.Bucket([]byte(sessionsBucket)).Get([]byte(id))
+	if value == nil {
+		return nil, monban.ErrNotFound
+	}
+	s := new(monban.Session)
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(s); err != nil {
+		return nil, fmt.Errorf("could not decode session: %v", err)
+	}
+	return s, nil
+}
+
+func deleteSession(tx *bolt.Tx, id string) error {
+	if err := tx.Bucket([]byte(sessionsBucket)).Delete([]byte(id)); err != nil {
+		return fmt.Errorf("could not delete session: %v", err)
+	}
+	return nil
+}
+
+// reapSessions removes the sessions that have no whitelisted token left. They
+// are normally removed along with their tokens but a session may be stored
+// after its tokens have been deleted.
+func (db *Whitelist) reapSessions() error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		wb := tx.Bucket([]byte(whitelistBucket))
+		// Collect the keys first as deleting while iterating with a cursor
+		// may skip keys.
+		var orphaned []string
+		err := tx.Bucket([]byte(sessionsBucket)).ForEach(func(k, v []byte) error {
+			for _, id := range indexed(tx, familiesBucket, string(k)) {
+				if wb.Get([]byte(id)) != nil {
+					return nil
+				}
+			}
+			orphaned = append(orphaned, string(k))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(orphaned) == 0 {
+			return errNothingToReap
+		}
+		for _, id := range orphaned {
+			if err := deleteSession(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == errNothingToReap {
+		return nil
+	}
+	return err
+}
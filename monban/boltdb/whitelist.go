@@ -4,60 +4,263 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/boltdb/bolt"
 	"github.com/kusubooru/monban/jwt"
+	"github.com/kusubooru/monban/monban"
 )
 
 func (db *Whitelist) GetToken(tokenID string) (*jwt.Token, error) {
-	tok := new(jwt.Token)
-	buf := bytes.Buffer{}
+	var tok *jwt.Token
 	err := db.View(func(tx *bolt.Tx) error {
 		value := tx.Bucket([]byte(whitelistBucket)).Get([]byte(tokenID))
-		// Discard the first 8 bytes because that's where we store the time the
-		// token was issued.
-		value = value[8:]
-
-		if _, werr := buf.Write(value); werr != nil {
-			return fmt.Errorf("could not write 'GetToken value' to buffer: %v", werr)
+		if value == nil {
+			return monban.ErrNotFound
 		}
+		var err error
+		tok, err = decodeToken(value)
+		return err
+	})
+	return tok, err
+}
+
+// decodeToken decodes a token stored in the whitelist bucket.
+func decodeToken(value []byte) (*jwt.Token, error) {
+	tok := new(jwt.Token)
+	buf := bytes.Buffer{}
+
+	// Discard the first 8 bytes because that's where we store the time the
+	// token was issued.
+	value = value[8:]
+
+	if _, werr := buf.Write(value); werr != nil {
+		return nil, fmt.Errorf("could not write 'GetToken value' to buffer: %v", werr)
+	}
+
+	if err := gob.NewDecoder(&buf).Decode(tok); err != nil {
+		return nil, fmt.Errorf("could not decode token %v", err)
+	}
+	return tok, nil
+}
+
+func (db *Whitelist) PutToken(tokenID string, tok *jwt.Token) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return putToken(tx, tokenID, tok)
+	})
+}
+
+func putToken(tx *bolt.Tx, tokenID string, tok *jwt.Token) error {
+	buf := bytes.Buffer{}
+
+	// Write the time the token was issued at as the first 8 bytes of the
+	// value. This is useful to easier find out which tokens are expired and
+	// delete them.
+	time := tok.IssuedAt
+	if time < 0 {
+		return fmt.Errorf("token has negative time")
+	}
+	buf.Write(itob(time))
+
+	// get bucket
+	b := tx.Bucket([]byte(whitelistBucket))
+
+	if err := gob.NewEncoder(&buf).Encode(tok); err != nil {
+		return fmt.Errorf("could not encode new PutToken value: %v", err)
+	}
 
-		if err := gob.NewDecoder(&buf).Decode(tok); err != nil {
-			return fmt.Errorf("could not decode token %v", err)
+	if err := b.Put([]byte(tokenID), buf.Bytes()); err != nil {
+		return fmt.Errorf("could not put value: %v", err)
+	}
+
+	// Index the token by subject and family so that all the tokens of a user
+	// or a session can be found.
+	sb := tx.Bucket([]byte(subjectsBucket))
+	if err := sb.Put(indexKey(tok.Subject, tokenID), nil); err != nil {
+		return fmt.Errorf("could not put subject index: %v", err)
+	}
+	fb := tx.Bucket([]byte(familiesBucket))
+	if err := fb.Put(indexKey(tok.Family, tokenID), nil); err != nil {
+		return fmt.Errorf("could not put family index: %v", err)
+	}
+	return nil
+}
+
+// DeleteToken removes a token from the whitelist. Deleting a token that does
+// not exist is not an error.
+func (db *Whitelist) DeleteToken(tokenID string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		return deleteToken(tx, whitelistBucket, tokenID)
+	})
+}
+
+// DeleteUserTokens removes all the tokens of a subject from the whitelist.
+func (db *Whitelist) DeleteUserTokens(subject string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, id := range indexed(tx, subjectsBucket, subject) {
+			if err := deleteToken(tx, whitelistBucket, id); err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte(subjectsBucket)).Delete(indexKey(subject, id)); err != nil {
+				return fmt.Errorf("could not delete subject index: %v", err)
+			}
 		}
 		return nil
 	})
-	return tok, err
 }
 
-func (db *Whitelist) PutToken(tokenID string, tok *jwt.Token) error {
+// UseToken removes a token from the whitelist and marks it as used. The token
+// is returned so that it can be exchanged for a new one. If the token has
+// already been used, UseToken returns the token along with
+// monban.ErrTokenReused.
+func (db *Whitelist) UseToken(tokenID string) (*jwt.Token, error) {
+	var tok *jwt.Token
 	err := db.Update(func(tx *bolt.Tx) error {
-		buf := bytes.Buffer{}
-
-		// Write the time the token was issued at as the first 8 bytes of the
-		// value. This is useful to easier find out which tokens are expired
-		// and delete them.
-		time := tok.IssuedAt
-		if time < 0 {
-			return fmt.Errorf("token has negative time")
-		}
-		buf.Write(itob(time))
+		var err error
+		tok, err = useToken(tx, tokenID)
+		return err
+	})
+	if err == monban.ErrTokenReused {
+		return tok, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
 
-		// get bucket
-		b := tx.Bucket([]byte(whitelistBucket))
+// RotateToken is like UseToken but also whitelists the next token of the
+// family and stores its session s in the same transaction. Nothing is changed
+// if the token cannot be used.
+func (db *Whitelist) RotateToken(tokenID string, next *jwt.Token, s *monban.Session) (*jwt.Token, error) {
+	var tok *jwt.Token
+	err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		tok, err = useToken(tx, tokenID)
+		if err != nil {
+			return err
+		}
+		if err := putToken(tx, next.ID, next); err != nil {
+			return err
+		}
+		return putSession(tx, s)
+	})
+	if err == monban.ErrTokenReused {
+		return tok, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
 
-		if err := gob.NewEncoder(&buf).Encode(tok); err != nil {
-			return fmt.Errorf("could not encode new PutToken value: %v", err)
+func useToken(tx *bolt.Tx, tokenID string) (*jwt.Token, error) {
+	wb := tx.Bucket([]byte(whitelistBucket))
+	ub := tx.Bucket([]byte(usedBucket))
+	value := wb.Get([]byte(tokenID))
+	if value == nil {
+		used := ub.Get([]byte(tokenID))
+		if used == nil {
+			return nil, monban.ErrNotFound
 		}
+		tok, err := decodeToken(used)
+		if err != nil {
+			return nil, err
+		}
+		return tok, monban.ErrTokenReused
+	}
+	tok, err := decodeToken(value)
+	if err != nil {
+		return nil, err
+	}
 
-		if err := b.Put([]byte(tokenID), buf.Bytes()); err != nil {
-			return fmt.Errorf("could not put value: %v", err)
+	// Copy the value as it is only valid until it gets deleted.
+	v := make([]byte, len(value))
+	copy(v, value)
+	if err := wb.Delete([]byte(tokenID)); err != nil {
+		return nil, fmt.Errorf("could not delete value: %v", err)
+	}
+	if err := tx.Bucket([]byte(subjectsBucket)).Delete(indexKey(tok.Subject, tokenID)); err != nil {
+		return nil, fmt.Errorf("could not delete subject index: %v", err)
+	}
+	if err := ub.Put([]byte(tokenID), v); err != nil {
+		return nil, fmt.Errorf("could not put used value: %v", err)
+	}
+	return tok, nil
+}
+
+// DeleteFamily removes all the whitelisted and used tokens of a token family.
+func (db *Whitelist) DeleteFamily(family string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		for _, id := range indexed(tx, familiesBucket, family) {
+			if err := deleteToken(tx, whitelistBucket, id); err != nil {
+				return err
+			}
+			if err := deleteToken(tx, usedBucket, id); err != nil {
+				return err
+			}
+			if err := tx.Bucket([]byte(familiesBucket)).Delete(indexKey(family, id)); err != nil {
+				return fmt.Errorf("could not delete family index: %v", err)
+			}
 		}
-		return nil
+		return deleteSession(tx, family)
 	})
-	return err
+}
+
+// indexed returns the IDs of the tokens indexed under prefix in the index
+// bucket. The IDs are collected before any deletion happens as deleting while
+// iterating with a cursor may skip keys.
+func indexed(tx *bolt.Tx, bucket, prefix string) []string {
+	var ids []string
+	p := indexKey(prefix, "")
+	c := tx.Bucket([]byte(bucket)).Cursor()
+	for k, _ := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, _ = c.Next() {
+		ids = append(ids, string(k[len(p):]))
+	}
+	return ids
+}
+
+// deleteToken removes a token and its index entries from a token bucket.
+func deleteToken(tx *bolt.Tx, bucket, tokenID string) error {
+	b := tx.Bucket([]byte(bucket))
+	value := b.Get([]byte(tokenID))
+	if value == nil {
+		return nil
+	}
+	tok, err := decodeToken(value)
+	if err != nil {
+		return err
+	}
+	if err := b.Delete([]byte(tokenID)); err != nil {
+		return fmt.Errorf("could not delete value: %v", err)
+	}
+	// A session ends when its whitelisted token is gone.
+	if bucket == whitelistBucket {
+		if err := deleteSession(tx, tok.Family); err != nil {
+			return err
+		}
+	}
+	return deleteIndexes(tx, tokenID, tok)
+}
+
+// deleteIndexes removes the index entries of a token.
+func deleteIndexes(tx *bolt.Tx, tokenID string, tok *jwt.Token) error {
+	sb := tx.Bucket([]byte(subjectsBucket))
+	if err := sb.Delete(indexKey(tok.Subject, tokenID)); err != nil {
+		return fmt.Errorf("could not delete subject index: %v", err)
+	}
+	fb := tx.Bucket([]byte(familiesBucket))
+	if err := fb.Delete(indexKey(tok.Family, tokenID)); err != nil {
+		return fmt.Errorf("could not delete family index: %v", err)
+	}
+	return nil
+}
+
+// indexKey returns the key used to index id under prefix.
+func indexKey(prefix, id string) []byte {
+	return []byte(prefix + "\x00" + id)
 }
 
 // itob returns an 8-byte big endian representation of v.
@@ -67,70 +270,56 @@ func itob(v int64) []byte {
 	return b
 }
 
-// Reap removes sessions older than a given duration.
-// This function assumes that all session data is stored in a "sessions" bucket
-// and the data is organized so the key is the session id and the value is
-// laid out as:
-//
-//   -8 bytes-   --n bytes--
-//   timestamp + sessiondata
-//
-// As written by Ben Johnson:
-// https://gist.github.com/benbjohnson/a3e9e35f73dae8d15c49
+// errNothingToReap rolls back the reaping transactions that find nothing to
+// delete so that they do not write to the database file.
+var errNothingToReap = errors.New("nothing to reap")
+
+// Reap removes the whitelisted and used refresh tokens issued more than
+// duration ago along with their indexes and sessions.
 //
 // Reap should run every second to clean up expired refresh tokens. Reap is
 // meant to be called manually, only once and on a separate goroutine at the
 // start of the program.
 func (db Whitelist) Reap(duration time.Duration) error {
-	// The batch size represents how many sessions we'll check at
-	// a time for a given transaction. We don't want to check all the
-	// sessions every time because that would lock the database for
-	// too long if the sessions bucket gets too large.
-	batchsz := 1000
-
-	var seek []byte
-	var prev []byte
 	for {
-		// Get the current timestamp.
-		now := time.Now()
+		if err := db.reapTokens(time.Now().Add(-duration)); err != nil {
+			return err
+		}
+		time.Sleep(1 * time.Second)
+	}
+}
 
-		// Iterate over a subset of keys at a time and delete old ones.
+// reapTokens removes the whitelisted and used refresh tokens issued before
+// the given time. The time the token was issued is stored as the first 8 bytes
+// of its value.
+func (db Whitelist) reapTokens(before time.Time) error {
+	for _, bucket := range []string{whitelistBucket, usedBucket} {
 		err := db.Update(func(tx *bolt.Tx) error {
-			c := tx.Bucket([]byte(whitelistBucket)).Cursor()
-
-			var i int
-			for k, v := c.Seek(prev); ; k, v = c.Next() {
-				// If we hit the end of our sessions then exit and start over next time.
-				if k == nil {
-					seek = nil
-					return nil
-				}
-
-				// If we have iterated over our batch size then save our place
-				// and we'll start from there next time. We need to copy over
-				// the bytes in "k" because it's not guarenteed to exist there
-				// after the transaction is over.
-				if i == batchsz {
-					seek = make([]byte, len(k))
-					copy(seek, k)
-					return nil
+			// Collect the keys first as deleting while iterating with a
+			// cursor may skip keys.
+			var expired []string
+			err := tx.Bucket([]byte(bucket)).ForEach(func(k, v []byte) error {
+				if int64(binary.BigEndian.Uint64(v)) < before.Unix() {
+					expired = append(expired, string(k))
 				}
-
-				// If we've made it this far then we can check if a session's
-				// timestamp is older than our expiration "duration". If so
-				// then we can delete the item in-place in the cursor.
-				timestamp := time.Unix(int64(binary.BigEndian.Uint64(v)), 0)
-				if now.Sub(timestamp) > duration {
-					if err := c.Delete(); err != nil {
-						return fmt.Errorf("delete: %s", err)
-					}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if len(expired) == 0 {
+				return errNothingToReap
+			}
+			for _, id := range expired {
+				if err := deleteToken(tx, bucket, id); err != nil {
+					return err
 				}
 			}
+			return nil
 		})
-		if err != nil {
+		if err != nil && err != errNothingToReap {
 			return err
 		}
-
-		time.Sleep(1 * time.Second)
 	}
+	return nil
 }
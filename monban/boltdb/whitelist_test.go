@@ -12,15 +12,15 @@ import (
 	"github.com/kusubooru/monban/monban"
 )
 
-func setup() (monban.Whitelist, *os.File) {
+func setup() (*DB, *os.File) {
 	f, err := ioutil.TempFile("", "monban_boltdb_tmpfile_")
 	if err != nil {
 		log.Fatal("could not create boltdb temp file for tests:", err)
 	}
-	return NewWhitelist(f.Name()), f
+	return Open(f.Name()), f
 }
 
-func teardown(whitelist monban.Whitelist, tmpfile *os.File) {
+func teardown(db *DB, tmpfile *os.File) {
 	//whitelist.Close()
 	if err := os.Remove(tmpfile.Name()); err != nil {
 		log.Println("could not remove boltdb temp file:", err)
@@ -28,8 +28,9 @@ func teardown(whitelist monban.Whitelist, tmpfile *os.File) {
 }
 
 func TestWhitelist(t *testing.T) {
-	whitelist, f := setup()
-	defer teardown(whitelist, f)
+	db, f := setup()
+	defer teardown(db, f)
+	whitelist := db.Whitelist()
 
 	tokenID := "123"
 	now := time.Now().Unix()
@@ -53,3 +54,526 @@ func TestWhitelist(t *testing.T) {
 		t.Errorf("whitelist.GetToken(%q, %#v) lead to \n%#v, want \n%#v", tokenID, tok, got, want)
 	}
 }
+
+func TestWhitelist_DeleteToken(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+	whitelist := db.Whitelist()
+
+	tokenID := "123"
+	tok := &jwt.Token{ID: tokenID, IssuedAt: time.Now().Unix()}
+	if err := whitelist.PutToken(tokenID, tok); err != nil {
+		t.Fatal("whitelist.PutToken:", err)
+	}
+	if err := whitelist.DeleteToken(tokenID); err != nil {
+		t.Fatal("whitelist.DeleteToken:", err)
+	}
+	if _, err := whitelist.GetToken(tokenID); err != monban.ErrNotFound {
+		t.Errorf("whitelist.GetToken(%q) after delete returned err %v, want %v", tokenID, err, monban.ErrNotFound)
+	}
+}
+
+func TestWhitelist_DeleteUserTokens(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+	whitelist := db.Whitelist()
+
+	now := time.Now().Unix()
+	tokens := []*jwt.Token{
+		{ID: "1", Subject: "2", IssuedAt: now},
+		{ID: "2", Subject: "2", IssuedAt: now},
+		{ID: "3", Subject: "20", IssuedAt: now},
+	}
+	for _, tok := range tokens {
+		if err := whitelist.PutToken(tok.ID, tok); err != nil {
+			t.Fatal("whitelist.PutToken:", err)
+		}
+	}
+
+	if err := whitelist.DeleteUserTokens("2"); err != nil {
+		t.Fatal("whitelist.DeleteUserTokens:", err)
+	}
+	for _, id := range []string{"1", "2"} {
+		if _, err := whitelist.GetToken(id); err != monban.ErrNotFound {
+			t.Errorf("whitelist.GetToken(%q) after DeleteUserTokens returned err %v, want %v", id, err, monban.ErrNotFound)
+		}
+	}
+	if _, err := whitelist.GetToken("3"); err != nil {
+		t.Errorf("whitelist.GetToken(%q) of other subject returned err: %v", "3", err)
+	}
+}
+
+func TestWhitelist_UseToken(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+	whitelist := db.Whitelist()
+
+	tokenID := "123"
+	tok := &jwt.Token{ID: tokenID, Family: tokenID, IssuedAt: time.Now().Unix()}
+	if err := whitelist.PutToken(tokenID, tok); err != nil {
+		t.Fatal("whitelist.PutToken:", err)
+	}
+
+	got, err := whitelist.UseToken(tokenID)
+	if err != nil {
+		t.Fatal("whitelist.UseToken:", err)
+	}
+	if !reflect.DeepEqual(got, tok) {
+		t.Errorf("whitelist.UseToken(%q) = \n%#v, want \n%#v", tokenID, got, tok)
+	}
+	if _, err := whitelist.GetToken(tokenID); err != monban.ErrNotFound {
+		t.Errorf("whitelist.GetToken(%q) after use returned err %v, want %v", tokenID, err, monban.ErrNotFound)
+	}
+
+	got, err = whitelist.UseToken(tokenID)
+	if err != monban.ErrTokenReused {
+		t.Fatalf("whitelist.UseToken(%q) second time returned err %v, want %v", tokenID, err, monban.ErrTokenReused)
+	}
+	if got == nil || got.Family != tok.Family {
+		t.Errorf("whitelist.UseToken(%q) second time = %#v, want token of family %q", tokenID, got, tok.Family)
+	}
+
+	if _, err := whitelist.UseToken("unknown"); err != monban.ErrNotFound {
+		t.Errorf("whitelist.UseToken(%q) returned err %v, want %v", "unknown", err, monban.ErrNotFound)
+	}
+}
+
+func TestWhitelist_RotateToken(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+	whitelist := db.Whitelist()
+
+	now := time.Now().Unix()
+	tok := &jwt.Token{ID: "1", Family: "1", Subject: "2", IssuedAt: now}
+	if err := whitelist.PutToken(tok.ID, tok); err != nil {
+		t.Fatal("whitelist.PutToken:", err)
+	}
+	next := &jwt.Token{ID: "2", Family: "1", Subject: "2", IssuedAt: now}
+	sess := &monban.Session{ID: "1", Subject: "2", IP: "127.0.0.1"}
+
+	got, err := whitelist.RotateToken(tok.ID, next, sess)
+	if err != nil {
+		t.Fatal("whitelist.RotateToken:", err)
+	}
+	if !reflect.DeepEqual(got, tok) {
+		t.Errorf("whitelist.RotateToken(%q) = \n%#v, want \n%#v", tok.ID, got, tok)
+	}
+	if _, err := whitelist.GetToken(tok.ID); err != monban.ErrNotFound {
+		t.Errorf("whitelist.GetToken(%q) after rotation returned err %v, want %v", tok.ID, err, monban.ErrNotFound)
+	}
+	if _, err := whitelist.GetToken(next.ID); err != nil {
+		t.Errorf("whitelist.GetToken(%q) of next token returned err: %v", next.ID, err)
+	}
+	if s, err := whitelist.GetSession(sess.ID); err != nil || s.IP != sess.IP {
+		t.Errorf("whitelist.GetSession(%q) = %#v, %v, want %#v", sess.ID, s, err, sess)
+	}
+
+	// Rotating a used token changes nothing.
+	other := &jwt.Token{ID: "3", Family: "1", Subject: "2", IssuedAt: now}
+	got, err = whitelist.RotateToken(tok.ID, other, sess)
+	if err != monban.ErrTokenReused {
+		t.Fatalf("whitelist.RotateToken(%q) second time returned err %v, want %v", tok.ID, err, monban.ErrTokenReused)
+	}
+	if got == nil || got.Family != tok.Family {
+		t.Errorf("whitelist.RotateToken(%q) second time = %#v, want token of family %q", tok.ID, got, tok.Family)
+	}
+	if _, err := whitelist.GetToken(other.ID); err != monban.ErrNotFound {
+		t.Errorf("whitelist.GetToken(%q) after failed rotation returned err %v, want %v", other.ID, err, monban.ErrNotFound)
+	}
+	if _, err := whitelist.RotateToken("unknown", other, sess); err != monban.ErrNotFound {
+		t.Errorf("whitelist.RotateToken(%q) returned err %v, want %v", "unknown", err, monban.ErrNotFound)
+	}
+}
+
+func TestWhitelist_DeleteFamily(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+	whitelist := db.Whitelist()
+
+	now := time.Now().Unix()
+	tokens := []*jwt.Token{
+		{ID: "1", Family: "1", IssuedAt: now},
+		{ID: "2", Family: "1", IssuedAt: now},
+		{ID: "3", Family: "3", IssuedAt: now},
+	}
+	for _, tok := range tokens {
+		if err := whitelist.PutToken(tok.ID, tok); err != nil {
+			t.Fatal("whitelist.PutToken:", err)
+		}
+	}
+	if _, err := whitelist.UseToken("1"); err != nil {
+		t.Fatal("whitelist.UseToken:", err)
+	}
+
+	if err := whitelist.DeleteFamily("1"); err != nil {
+		t.Fatal("whitelist.DeleteFamily:", err)
+	}
+	if _, err := whitelist.GetToken("2"); err != monban.ErrNotFound {
+		t.Errorf("whitelist.GetToken(%q) after DeleteFamily returned err %v, want %v", "2", err, monban.ErrNotFound)
+	}
+	if _, err := whitelist.UseToken("1"); err != monban.ErrNotFound {
+		t.Errorf("whitelist.UseToken(%q) after DeleteFamily returned err %v, want %v", "1", err, monban.ErrNotFound)
+	}
+	if _, err := whitelist.GetToken("3"); err != nil {
+		t.Errorf("whitelist.GetToken(%q) of other family returned err: %v", "3", err)
+	}
+}
+
+func TestWhitelist_ListUserTokens(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+	whitelist := db.Whitelist()
+
+	now := time.Unix(time.Now().Unix(), 0)
+	tok := &jwt.Token{ID: "1", Family: "1", Subject: "2", IssuedAt: now.Unix()}
+	if err := whitelist.PutToken(tok.ID, tok); err != nil {
+		t.Fatal("whitelist.PutToken:", err)
+	}
+	sess := &monban.Session{
+		ID:          "1",
+		Subject:     "2",
+		Created:     now,
+		LastRefresh: now,
+		IP:          "127.0.0.1",
+		UserAgent:   "test",
+	}
+	if err := whitelist.PutSession(sess); err != nil {
+		t.Fatal("whitelist.PutSession:", err)
+	}
+
+	got, err := whitelist.ListUserTokens("2")
+	if err != nil {
+		t.Fatal("whitelist.ListUserTokens:", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("whitelist.ListUserTokens(%q) returned %d sessions, want 1", "2", len(got))
+	}
+	if got[0].ID != sess.ID || got[0].IP != sess.IP || got[0].UserAgent != sess.UserAgent || !got[0].Created.Equal(now) {
+		t.Errorf("whitelist.ListUserTokens(%q) = \n%#v, want \n%#v", "2", got[0], sess)
+	}
+
+	if err := whitelist.DeleteFamily("1"); err != nil {
+		t.Fatal("whitelist.DeleteFamily:", err)
+	}
+	if _, err := whitelist.GetSession("1"); err != monban.ErrNotFound {
+		t.Errorf("whitelist.GetSession(%q) after DeleteFamily returned err %v, want %v", "1", err, monban.ErrNotFound)
+	}
+}
+
+func TestDenylist_DenyToken(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+
+	denylist := db.Denylist()
+	exp := time.Now().Add(time.Minute).Unix()
+	if err := denylist.DenyToken("1", exp); err != nil {
+		t.Fatal("denylist.DenyToken:", err)
+	}
+	for id, want := range map[string]bool{"1": true, "2": false} {
+		got, err := denylist.IsDenied(&jwt.Token{ID: id})
+		if err != nil {
+			t.Fatalf("denylist.IsDenied(%q) returned err: %v", id, err)
+		}
+		if got != want {
+			t.Errorf("denylist.IsDenied(%q) = %t, want %t", id, got, want)
+		}
+	}
+}
+
+func TestDenylist_DenySubject(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+
+	denylist := db.Denylist()
+	for i := 0; i < 2; i++ {
+		if err := denylist.DenySubject("2"); err != nil {
+			t.Fatal("denylist.DenySubject:", err)
+		}
+	}
+	gen, err := denylist.SubjectGeneration("2")
+	if err != nil {
+		t.Fatal("denylist.SubjectGeneration:", err)
+	}
+	if got, want := gen, int64(2); got != want {
+		t.Errorf("denylist.SubjectGeneration(%q) = %d, want %d", "2", got, want)
+	}
+	tests := []struct {
+		tok  *jwt.Token
+		want bool
+	}{
+		{&jwt.Token{Subject: "2"}, true},
+		{&jwt.Token{Subject: "2", Generation: 1}, true},
+		{&jwt.Token{Subject: "2", Generation: 2}, false},
+		{&jwt.Token{Subject: "3"}, false},
+	}
+	for _, tt := range tests {
+		got, err := denylist.IsDenied(tt.tok)
+		if err != nil {
+			t.Fatalf("denylist.IsDenied(%#v) returned err: %v", tt.tok, err)
+		}
+		if got != tt.want {
+			t.Errorf("denylist.IsDenied(%#v) = %t, want %t", tt.tok, got, tt.want)
+		}
+	}
+}
+
+func TestWhitelist_reapTokens(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+	whitelist := db.Whitelist()
+
+	now := time.Now()
+	old := now.Add(-2 * time.Hour).Unix()
+	for _, tok := range []*jwt.Token{
+		{ID: "1", Family: "1", Subject: "2", IssuedAt: old},
+		{ID: "2", Family: "2", Subject: "2", IssuedAt: now.Unix()},
+		{ID: "3", Family: "3", Subject: "2", IssuedAt: old},
+	} {
+		if err := whitelist.PutToken(tok.ID, tok); err != nil {
+			t.Fatal("whitelist.PutToken:", err)
+		}
+	}
+	if _, err := whitelist.UseToken("3"); err != nil {
+		t.Fatal("whitelist.UseToken:", err)
+	}
+
+	if err := whitelist.reapTokens(now.Add(-time.Hour)); err != nil {
+		t.Fatal("whitelist.reapTokens:", err)
+	}
+	if _, err := whitelist.GetToken("1"); err != monban.ErrNotFound {
+		t.Errorf("whitelist.GetToken(%q) of old token after reap returned err %v, want %v", "1", err, monban.ErrNotFound)
+	}
+	if _, err := whitelist.GetToken("2"); err != nil {
+		t.Errorf("whitelist.GetToken(%q) of new token after reap returned err: %v", "2", err)
+	}
+	if _, err := whitelist.UseToken("3"); err != monban.ErrNotFound {
+		t.Errorf("whitelist.UseToken(%q) of old used token after reap returned err %v, want %v", "3", err, monban.ErrNotFound)
+	}
+	sessions, err := whitelist.ListUserTokens("2")
+	if err != nil {
+		t.Fatal("whitelist.ListUserTokens:", err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "2" {
+		t.Errorf("whitelist.ListUserTokens after reap = %#v, want only session %q", sessions, "2")
+	}
+}
+
+func TestWhitelist_reapSessions(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+	whitelist := db.Whitelist()
+
+	now := time.Now().Unix()
+	tok := &jwt.Token{ID: "1", Family: "1", Subject: "2", IssuedAt: now}
+	if err := whitelist.PutToken(tok.ID, tok); err != nil {
+		t.Fatal("whitelist.PutToken:", err)
+	}
+	// Session "2" was stored after its tokens were deleted.
+	for _, id := range []string{"1", "2"} {
+		if err := whitelist.PutSession(&monban.Session{ID: id, Subject: "2"}); err != nil {
+			t.Fatal("whitelist.PutSession:", err)
+		}
+	}
+
+	if err := whitelist.reapSessions(); err != nil {
+		t.Fatal("whitelist.reapSessions:", err)
+	}
+	if _, err := whitelist.GetSession("1"); err != nil {
+		t.Errorf("whitelist.GetSession(%q) of whitelisted token returned err: %v", "1", err)
+	}
+	if _, err := whitelist.GetSession("2"); err != monban.ErrNotFound {
+		t.Errorf("whitelist.GetSession(%q) after reap returned err %v, want %v", "2", err, monban.ErrNotFound)
+	}
+}
+
+func TestDB_reapExpired(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+
+	denylist := db.Denylist()
+	now := time.Now()
+	if err := denylist.DenyToken("1", now.Add(-time.Minute).Unix()); err != nil {
+		t.Fatal("denylist.DenyToken:", err)
+	}
+	if err := denylist.DenyToken("2", now.Add(time.Minute).Unix()); err != nil {
+		t.Fatal("denylist.DenyToken:", err)
+	}
+	if err := db.reapExpired(now); err != nil {
+		t.Fatal("reapExpired failed:", err)
+	}
+	for id, want := range map[string]bool{"1": false, "2": true} {
+		got, err := denylist.IsDenied(&jwt.Token{ID: id})
+		if err != nil {
+			t.Fatalf("denylist.IsDenied(%q) returned err: %v", id, err)
+		}
+		if got != want {
+			t.Errorf("after reap denylist.IsDenied(%q) = %t, want %t", id, got, want)
+		}
+	}
+	// Nothing is left to reap which must not be reported as an error.
+	if err := db.reapExpired(now); err != nil {
+		t.Fatal("reapExpired with nothing to reap failed:", err)
+	}
+}
+
+func TestCodeStore_UseCode(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+
+	codes := db.CodeStore()
+	c := &monban.AuthCode{
+		Code:        "hash",
+		ClientID:    "client",
+		RedirectURI: "https://example.com/callback",
+		Subject:     "2",
+		Challenge:   "challenge",
+		ExpiresAt:   time.Now().Add(time.Minute).Unix(),
+	}
+	if err := codes.PutCode(c); err != nil {
+		t.Fatal("codes.PutCode:", err)
+	}
+	// Getting a code leaves it in place.
+	for i := 0; i < 2; i++ {
+		got, err := codes.GetCode("hash")
+		if err != nil {
+			t.Fatal("codes.GetCode:", err)
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Errorf("codes.GetCode(%q) = \n%#v, want \n%#v", "hash", got, c)
+		}
+	}
+	// A used code is kept with the family of its first use.
+	used := *c
+	used.Used = true
+	used.Family = "family"
+	got, err := codes.UseCode("hash", "family")
+	if err != nil {
+		t.Fatal("codes.UseCode:", err)
+	}
+	if !reflect.DeepEqual(got, &used) {
+		t.Errorf("codes.UseCode(%q) = \n%#v, want \n%#v", "hash", got, &used)
+	}
+	got, err = codes.UseCode("hash", "other")
+	if err != monban.ErrCodeReused {
+		t.Errorf("codes.UseCode(%q) second time returned err %v, want %v", "hash", err, monban.ErrCodeReused)
+	}
+	if !reflect.DeepEqual(got, &used) {
+		t.Errorf("codes.UseCode(%q) second time = \n%#v, want \n%#v", "hash", got, &used)
+	}
+	got, err = codes.GetCode("hash")
+	if err != nil {
+		t.Fatal("codes.GetCode after use:", err)
+	}
+	if !reflect.DeepEqual(got, &used) {
+		t.Errorf("codes.GetCode(%q) after use = \n%#v, want \n%#v", "hash", got, &used)
+	}
+	if _, err := codes.UseCode("unknown", "family"); err != monban.ErrNotFound {
+		t.Errorf("codes.UseCode(%q) returned err %v, want %v", "unknown", err, monban.ErrNotFound)
+	}
+}
+
+func TestDeviceStore_PollDeviceCode(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+
+	devices := db.DeviceStore()
+	now := time.Now().Unix()
+	d := &monban.DeviceCode{
+		Code:      "hash",
+		UserCode:  "BCDFGHJK",
+		ClientID:  "client",
+		Status:    monban.DevicePending,
+		Interval:  5,
+		ExpiresAt: now + 60,
+	}
+	if err := devices.PutDeviceCode(d); err != nil {
+		t.Fatal("devices.PutDeviceCode:", err)
+	}
+	if err := devices.PutDeviceCode(&monban.DeviceCode{Code: "other", UserCode: d.UserCode}); err == nil {
+		t.Error("devices.PutDeviceCode with existing user code expected to return err")
+	}
+
+	got, err := devices.PollDeviceCode("hash", now)
+	if err != nil {
+		t.Fatal("devices.PollDeviceCode:", err)
+	}
+	if !reflect.DeepEqual(got, d) {
+		t.Errorf("devices.PollDeviceCode(%q) = \n%#v, want \n%#v", "hash", got, d)
+	}
+
+	approved, err := devices.GetDeviceCode(d.UserCode)
+	if err != nil {
+		t.Fatal("devices.GetDeviceCode:", err)
+	}
+	if approved.LastPoll != now {
+		t.Errorf("devices.GetDeviceCode(%q) after poll has LastPoll %d, want %d", d.UserCode, approved.LastPoll, now)
+	}
+	if err := devices.SlowDownDeviceCode("hash", 5); err != nil {
+		t.Fatal("devices.SlowDownDeviceCode:", err)
+	}
+	slowed, err := devices.GetDeviceCode(d.UserCode)
+	if err != nil {
+		t.Fatal("devices.GetDeviceCode:", err)
+	}
+	if slowed.Interval != d.Interval+5 {
+		t.Errorf("devices.GetDeviceCode(%q) after slow down has Interval %d, want %d", d.UserCode, slowed.Interval, d.Interval+5)
+	}
+	approved = slowed
+	approved.Status = monban.DeviceApproved
+	approved.Subject = "2"
+	if err := devices.UpdateDeviceCode(approved); err != nil {
+		t.Fatal("devices.UpdateDeviceCode:", err)
+	}
+
+	got, err = devices.PollDeviceCode("hash", now+5)
+	if err != nil {
+		t.Fatal("devices.PollDeviceCode:", err)
+	}
+	if !reflect.DeepEqual(got, approved) {
+		t.Errorf("devices.PollDeviceCode(%q) after approval = \n%#v, want \n%#v", "hash", got, approved)
+	}
+	if _, err := devices.PollDeviceCode("hash", now+10); err != monban.ErrNotFound {
+		t.Errorf("devices.PollDeviceCode(%q) after approved poll returned err %v, want %v", "hash", err, monban.ErrNotFound)
+	}
+	if _, err := devices.GetDeviceCode(d.UserCode); err != monban.ErrNotFound {
+		t.Errorf("devices.GetDeviceCode(%q) after approved poll returned err %v, want %v", d.UserCode, err, monban.ErrNotFound)
+	}
+	if err := devices.UpdateDeviceCode(approved); err != monban.ErrNotFound {
+		t.Errorf("devices.UpdateDeviceCode after approved poll returned err %v, want %v", err, monban.ErrNotFound)
+	}
+}
+
+func TestResetStore_UseResetToken(t *testing.T) {
+	db, f := setup()
+	defer teardown(db, f)
+
+	resets := db.ResetStore()
+	rt := &monban.ResetToken{
+		Hash:      "hash",
+		UserID:    2,
+		ExpiresAt: time.Now().Add(time.Minute).Unix(),
+	}
+	other := &monban.ResetToken{Hash: "other", UserID: 2, ExpiresAt: rt.ExpiresAt}
+	kept := &monban.ResetToken{Hash: "kept", UserID: 3, ExpiresAt: rt.ExpiresAt}
+	for _, t2 := range []*monban.ResetToken{rt, other, kept} {
+		if err := resets.PutResetToken(t2); err != nil {
+			t.Fatal("resets.PutResetToken:", err)
+		}
+	}
+	got, err := resets.UseResetToken("hash")
+	if err != nil {
+		t.Fatal("resets.UseResetToken:", err)
+	}
+	if !reflect.DeepEqual(got, rt) {
+		t.Errorf("resets.UseResetToken(%q) = \n%#v, want \n%#v", "hash", got, rt)
+	}
+	if _, err := resets.UseResetToken("hash"); err != monban.ErrNotFound {
+		t.Errorf("resets.UseResetToken(%q) second time returned err %v, want %v", "hash", err, monban.ErrNotFound)
+	}
+	if _, err := resets.UseResetToken("other"); err != monban.ErrNotFound {
+		t.Errorf("resets.UseResetToken(%q) of the same user returned err %v, want %v", "other", err, monban.ErrNotFound)
+	}
+	if _, err := resets.UseResetToken("kept"); err != nil {
+		t.Errorf("resets.UseResetToken(%q) of another user returned err: %v", "kept", err)
+	}
+}
@@ -0,0 +1,183 @@
+package monban
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+// Client is an application that is registered with Monban and authenticates
+// with its ID and secret, for example a service that introspects tokens or an
+// application that lets its users log in through Monban.
+type Client struct {
+	ID   string
+	Name string
+	// Secret is the plain text secret when creating a client and its bcrypt
+	// hash when retrieving one.
+	Secret string
+	// Public clients, such as browser or mobile applications, cannot keep
+	// a secret. They have none and authenticate with their ID alone.
+	Public bool
+	// RedirectURIs are the URIs that users can be sent back to after
+	// authorizing the client.
+	RedirectURIs []string
+	// Scopes are the scopes the client can request tokens for. They are
+	// granted to the client itself with the client credentials grant and
+	// they also cap the scopes users can grant to the client through the
+	// authorization code, device and refresh grants, apart from the OpenID
+	// Connect scopes which are always allowed.
+	Scopes []string
+	// Audience lists the services the access tokens issued to the client
+	// itself are intended for. If empty, they are only intended for Monban.
+	Audience []string
+	Created  time.Time
+}
+
+// ClientStore specifies the operations needed for storing and retrieving
+// registered clients.
+type ClientStore interface {
+	CreateClient(c *Client) error
+	GetClient(id string) (*Client, error)
+}
+
+// clientSecretSize is the size of the client secrets in bytes.
+const clientSecretSize = 32
+
+// RegisterClient registers client c with a new ID and secret. The secret is
+// returned once and only its hash is stored. Public clients get no secret.
+func (s *authService) RegisterClient(c *Client) (string, error) {
+	var secret string
+	if !c.Public {
+		var err error
+		secret, err = randomString(clientSecretSize)
+		if err != nil {
+			return "", fmt.Errorf("client secret creation failed: %v", err)
+		}
+	}
+	c.ID = jwt.NewUUID()
+	c.Secret = secret
+	if err := s.clients.CreateClient(c); err != nil {
+		return "", fmt.Errorf("create client: %v", err)
+	}
+	return secret, nil
+}
+
+// randomString returns a URL-safe, base64 encoded random string of n bytes.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthenticateClient verifies the credentials of a client. Public clients
+// authenticate with an empty secret. It returns ErrWrongCredentials if the
+// client does not exist or the secret is wrong.
+func (s *authService) AuthenticateClient(clientID, secret string) (*Client, error) {
+	if clientID == "" {
+		return nil, ErrWrongCredentials
+	}
+	c, err := s.clients.GetClient(clientID)
+	switch err {
+	case ErrNotFound:
+		return nil, ErrWrongCredentials
+	case nil:
+	default:
+		return nil, fmt.Errorf("get client: %v", err)
+	}
+	if c.Public {
+		if secret != "" {
+			return nil, ErrWrongCredentials
+		}
+		return c, nil
+	}
+	if secret == "" {
+		return nil, ErrWrongCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(c.Secret), []byte(secret)); err != nil {
+		return nil, ErrWrongCredentials
+	}
+	return c, nil
+}
+
+// hasRedirectURI reports whether uri is one of the redirect URIs of client c.
+// URIs are compared exactly as advised by the OAuth 2.0 Security BCP.
+func (c *Client) hasRedirectURI(uri string) bool {
+	for _, u := range c.RedirectURIs {
+		if u == uri {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	// ErrUnauthorizedClient is returned when a client uses a grant it is
+	// not allowed to.
+	ErrUnauthorizedClient = errors.New("client not allowed to use grant")
+	// ErrInvalidScope is returned when tokens are requested for a scope that
+	// is not allowed.
+	ErrInvalidScope = errors.New("scope not allowed")
+)
+
+// ClientCredentials issues an access token to client c itself as defined by
+// the client credentials grant of OAuth 2.0. The subject of the token is the
+// client. The requested scope must be a subset of the scopes of the client.
+// If it is empty, the token gets all the scopes of the client. No refresh
+// token is issued as the client can always ask for a new access token. The
+// token has no name claim which is reserved for the names of users.
+func (s *authService) ClientCredentials(c *Client, scope string) (*Grant, error) {
+	if c.Public {
+		return nil, ErrUnauthorizedClient
+	}
+	scopes := strings.Fields(scope)
+	for _, sc := range scopes {
+		if !contains(c.Scopes, sc) {
+			return nil, ErrInvalidScope
+		}
+	}
+	if len(scopes) == 0 {
+		scopes = c.Scopes
+	}
+	audience := c.Audience
+	if len(audience) == 0 {
+		audience = []string{s.issuer}
+	}
+
+	now := time.Now()
+	accessToken := &jwt.Token{
+		Type:      jwt.TypeAccess,
+		ID:        jwt.NewUUID(),
+		Subject:   c.ID,
+		Issuer:    s.issuer,
+		Audience:  audience,
+		Scope:     scopes,
+		Duration:  s.accTokDur,
+		ExpiresAt: now.Add(s.accTokDur).Unix(),
+		IssuedAt:  now.Unix(),
+		Claims:    map[string]interface{}{claimClientID: c.ID},
+	}
+	signed, err := s.keys.Encode(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("access token creation failed: %v", err)
+	}
+	return &Grant{Access: signed, ExpiresIn: s.accTokDur, Scope: scopes}, nil
+}
+
+// contains reports whether list contains s.
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,330 @@
+package monban
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrAuthorizationPending is returned when a device polls for tokens
+	// before the user has approved or denied its request.
+	ErrAuthorizationPending = errors.New("authorization pending")
+	// ErrSlowDown is returned when a device polls for tokens more often than
+	// its polling interval.
+	ErrSlowDown = errors.New("slow down")
+	// ErrExpiredToken is returned when a device polls for tokens after its
+	// device code has expired.
+	ErrExpiredToken = errors.New("device code expired")
+	// ErrAccessDenied is returned when a device polls for tokens after the
+	// user denied its request.
+	ErrAccessDenied = errors.New("access denied")
+)
+
+// DeviceStatus is the state of a device authorization request.
+type DeviceStatus int
+
+const (
+	// DevicePending means that the user has not decided yet.
+	DevicePending DeviceStatus = iota
+	// DeviceApproved means that the user allowed the device.
+	DeviceApproved
+	// DeviceDenied means that the user denied the device.
+	DeviceDenied
+)
+
+// DeviceStore describes the storage of the device codes of the device
+// authorization grant. GetDeviceCode finds a device code by its user code.
+// PollDeviceCode finds a device code by its hash, records now as the last time
+// it was polled and returns it as it was before. Once a device code has been
+// approved or denied, PollDeviceCode must remove it so that tokens can be
+// issued for it only once. UpdateDeviceCode must only update device codes that
+// still exist. SlowDownDeviceCode finds a device code by its hash and adds by
+// to its interval without touching the rest of it so that an approval is not
+// lost. All of them return ErrNotFound for unknown device codes.
+type DeviceStore interface {
+	PutDeviceCode(d *DeviceCode) error
+	GetDeviceCode(userCode string) (*DeviceCode, error)
+	UpdateDeviceCode(d *DeviceCode) error
+	PollDeviceCode(code string, now int64) (*DeviceCode, error)
+	SlowDownDeviceCode(code string, by int64) error
+}
+
+// DeviceCode is a device authorization request as defined by RFC 8628. The
+// device polls for tokens with the device code while the user approves the
+// request by entering the user code.
+type DeviceCode struct {
+	// Code is the SHA-256 hash of the device code given to the device.
+	Code     string
+	UserCode string
+	ClientID string
+	// Scope is the space separated list of the requested scopes until the
+	// request is approved and of the granted scopes after.
+	Scope   string
+	Status  DeviceStatus
+	Subject string
+	// Interval is the minimum number of seconds between polls and LastPoll
+	// the time the device last polled.
+	Interval  int64
+	LastPoll  int64
+	AuthTime  int64
+	ExpiresAt int64
+}
+
+// DeviceAuthorization is the response to a device authorization request.
+type DeviceAuthorization struct {
+	DeviceCode string
+	// UserCode is the code the user enters to approve the request. It is
+	// formatted for display, for example "WDJB-MJHT".
+	UserCode string
+	// ExpiresIn is the lifetime of the device and user codes.
+	ExpiresIn time.Duration
+	// Interval is how long the device must wait between polls.
+	Interval time.Duration
+}
+
+const (
+	// deviceCodeDuration is how long device codes can be approved and polled
+	// for.
+	deviceCodeDuration = 10 * time.Minute
+	// deviceInterval is the initial polling interval of devices.
+	deviceInterval = 5 * time.Second
+	// deviceSlowDown is how much the polling interval of a device increases
+	// every time it polls too often as defined by RFC 8628.
+	deviceSlowDown = 5 * time.Second
+	// deviceCodeSize is the size of the device codes in bytes.
+	deviceCodeSize = 32
+	// userCodeAlphabet has no vowels to avoid forming words and no
+	// characters that are easily confused.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	// userCodeLen is the number of characters in a user code.
+	userCodeLen = 8
+)
+
+// RequestDeviceCode starts a device authorization request of client c for the
+// space separated scopes. The scopes are checked when the user approves the
+// request.
+func (s *authService) RequestDeviceCode(c *Client, scope string) (*DeviceAuthorization, error) {
+	code, err := randomString(deviceCodeSize)
+	if err != nil {
+		return nil, fmt.Errorf("device code creation failed: %v", err)
+	}
+	userCode, err := randomUserCode()
+	if err != nil {
+		return nil, fmt.Errorf("user code creation failed: %v", err)
+	}
+	d := &DeviceCode{
+		Code:      hashCode(code),
+		UserCode:  userCode,
+		ClientID:  c.ID,
+		Scope:     strings.Join(strings.Fields(scope), " "),
+		Status:    DevicePending,
+		Interval:  int64(deviceInterval / time.Second),
+		ExpiresAt: time.Now().Add(deviceCodeDuration).Unix(),
+	}
+	if err := s.devices.PutDeviceCode(d); err != nil {
+		return nil, fmt.Errorf("put device code: %v", err)
+	}
+	return &DeviceAuthorization{
+		DeviceCode: code,
+		UserCode:   userCode[:userCodeLen/2] + "-" + userCode[userCodeLen/2:],
+		ExpiresIn:  deviceCodeDuration,
+		Interval:   deviceInterval,
+	}, nil
+}
+
+// CheckUserCode returns the client of the pending device authorization request
+// with userCode so that the user can be asked to approve it. It returns
+// ErrInvalidGrant if there is no such request.
+func (s *authService) CheckUserCode(userCode string) (*Client, error) {
+	d, err := s.pendingDeviceCode(userCode)
+	if err != nil {
+		return nil, err
+	}
+	c, err := s.clients.GetClient(d.ClientID)
+	switch err {
+	case ErrNotFound:
+		return nil, ErrInvalidGrant
+	case nil:
+	default:
+		return nil, fmt.Errorf("get client: %v", err)
+	}
+	return c, nil
+}
+
+// ApproveDevice authenticates the user with username and password and
+// approves the device authorization request with userCode. The request is
+// granted the requested scopes that the user and the client are allowed.
+func (s *authService) ApproveDevice(username, password, userCode string) error {
+	d, err := s.pendingDeviceCode(userCode)
+	if err != nil {
+		return err
+	}
+	c, err := s.clients.GetClient(d.ClientID)
+	switch err {
+	case ErrNotFound:
+		return ErrInvalidGrant
+	case nil:
+	default:
+		return fmt.Errorf("get client: %v", err)
+	}
+	u, err := s.authenticateUser(username, password)
+	if err != nil {
+		return err
+	}
+	if u.Banned() {
+		return ErrWrongCredentials
+	}
+	scopes, err := s.grantScopes(u, c, strings.Fields(d.Scope))
+	if err != nil {
+		return err
+	}
+
+	d.Status = DeviceApproved
+	d.Subject = userSubject(u)
+	d.Scope = strings.Join(scopes, " ")
+	d.AuthTime = time.Now().Unix()
+	return s.updateDeviceCode(d)
+}
+
+// DenyDevice authenticates the user with username and password and denies the
+// device authorization request with userCode. Like approving, denying needs
+// the credentials of the user so that anyone who sees the user code cannot
+// deny the request.
+func (s *authService) DenyDevice(username, password, userCode string) error {
+	d, err := s.pendingDeviceCode(userCode)
+	if err != nil {
+		return err
+	}
+	if _, err := s.authenticateUser(username, password); err != nil {
+		return err
+	}
+	d.Status = DeviceDenied
+	return s.updateDeviceCode(d)
+}
+
+// PollDevice exchanges a device code issued to client c for tokens once the
+// user has approved the request. Until then it returns
+// ErrAuthorizationPending, or ErrSlowDown if the device polls too often.
+func (s *authService) PollDevice(c *Client, code string, req *TokenRequest) (*Grant, error) {
+	if code == "" {
+		return nil, ErrInvalidGrant
+	}
+	now := time.Now().Unix()
+	d, err := s.devices.PollDeviceCode(hashCode(code), now)
+	switch err {
+	case ErrNotFound:
+		return nil, ErrInvalidGrant
+	case nil:
+	default:
+		return nil, fmt.Errorf("poll device code: %v", err)
+	}
+	if d.ClientID != c.ID {
+		return nil, ErrInvalidGrant
+	}
+	if d.ExpiresAt < now {
+		return nil, ErrExpiredToken
+	}
+
+	switch d.Status {
+	case DeviceDenied:
+		return nil, ErrAccessDenied
+	case DevicePending:
+		// The device must increase its interval after a slow down error,
+		// as defined by RFC 8628, and so does the interval it is held to.
+		if d.LastPoll != 0 && now-d.LastPoll < d.Interval {
+			err := s.devices.SlowDownDeviceCode(d.Code, int64(deviceSlowDown/time.Second))
+			switch err {
+			case ErrNotFound, nil:
+				return nil, ErrSlowDown
+			default:
+				return nil, fmt.Errorf("slow down device code: %v", err)
+			}
+		}
+		return nil, ErrAuthorizationPending
+	}
+
+	u, err := s.subjectUser(d.Subject)
+	if err != nil {
+		return nil, ErrInvalidGrant
+	}
+	if req == nil {
+		req = new(TokenRequest)
+	}
+	req.ClientID = c.ID
+	grant, err := s.createTokens(u, nil, "", strings.Fields(d.Scope), req)
+	if err != nil {
+		return nil, err
+	}
+	if hasScope(d.Scope, scopeOpenID) {
+		ac := &AuthCode{ClientID: d.ClientID, AuthTime: d.AuthTime}
+		grant.IDToken, err = s.createIDToken(u, ac, grant.Access)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return grant, nil
+}
+
+// pendingDeviceCode returns the device authorization request with userCode if
+// it is still waiting for the user. It returns ErrInvalidGrant otherwise.
+func (s *authService) pendingDeviceCode(userCode string) (*DeviceCode, error) {
+	d, err := s.devices.GetDeviceCode(normalizeUserCode(userCode))
+	switch err {
+	case ErrNotFound:
+		return nil, ErrInvalidGrant
+	case nil:
+	default:
+		return nil, fmt.Errorf("get device code: %v", err)
+	}
+	if d.Status != DevicePending || d.ExpiresAt < time.Now().Unix() {
+		return nil, ErrInvalidGrant
+	}
+	return d, nil
+}
+
+func (s *authService) updateDeviceCode(d *DeviceCode) error {
+	err := s.devices.UpdateDeviceCode(d)
+	switch err {
+	case ErrNotFound:
+		return ErrInvalidGrant
+	case nil:
+		return nil
+	default:
+		return fmt.Errorf("update device code: %v", err)
+	}
+}
+
+// randomUserCode returns a random user code of userCodeLen characters from
+// userCodeAlphabet.
+func randomUserCode() (string, error) {
+	// Bytes past the largest multiple of the alphabet size are rejected so
+	// that every character is equally likely.
+	max := 256 - 256%len(userCodeAlphabet)
+	code := make([]byte, 0, userCodeLen)
+	b := make([]byte, userCodeLen)
+	for len(code) < userCodeLen {
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		for _, c := range b {
+			if int(c) < max && len(code) < userCodeLen {
+				code = append(code, userCodeAlphabet[int(c)%len(userCodeAlphabet)])
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// normalizeUserCode makes user codes entered by users case insensitive and
+// ignores dashes and spaces.
+func normalizeUserCode(userCode string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(userCode))
+}
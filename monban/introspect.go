@@ -0,0 +1,180 @@
+package monban
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+// Token types as used by token type hints in RFC 7009 and RFC 7662.
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+// Introspection describes the state of a token as defined by RFC 7662. Only
+// Active is set for tokens that are not active.
+type Introspection struct {
+	Active    bool
+	TokenType string
+	ID        string
+	Subject   string
+	Username  string
+	Class     string
+	Admin     bool
+	Issuer    string
+	Audience  []string
+	IssuedAt  int64
+	ExpiresAt int64
+	// Scope is the space separated list of the scopes of the token.
+	Scope string
+	// ClientID is taken from the "client_id" claim of the token if it has
+	// one.
+	ClientID string
+}
+
+// Introspect reports whether token is an active access, refresh or personal
+// access token issued by Monban. Refresh tokens are active only while they are
+// in the whitelist and access tokens only while they are not in the denylist.
+// Personal access tokens are reported as access tokens. Tokens that cannot be
+// decoded are not active and are not reported as an error.
+func (s *authService) Introspect(token string) (*Introspection, error) {
+	inactive := &Introspection{}
+	if isPersonalToken(token) {
+		tok, err := s.authenticatePersonalToken(token)
+		switch err {
+		case ErrInvalidToken:
+			return inactive, nil
+		case nil:
+			return introspection(tok, TokenTypeAccess), nil
+		default:
+			return nil, err
+		}
+	}
+	tok, valid, err := s.keys.Decode(token)
+	if err != nil {
+		if _, ok := err.(*jwt.Error); ok {
+			return inactive, nil
+		}
+		return nil, err
+	}
+	if !valid || tok.Issuer != s.issuer {
+		return inactive, nil
+	}
+
+	var tokenType string
+	switch s.tokenType(tok) {
+	case jwt.TypeRefresh:
+		wltok, err := s.whitelist.GetToken(tok.ID)
+		switch err {
+		case ErrNotFound:
+			return inactive, nil
+		case nil:
+		default:
+			return nil, err
+		}
+		if !s.verifyToken(tok, wltok) {
+			return inactive, nil
+		}
+		tokenType = TokenTypeRefresh
+	case jwt.TypeAccess:
+		denied, err := s.denied(tok)
+		if err != nil {
+			return nil, err
+		}
+		if denied {
+			return inactive, nil
+		}
+		tokenType = TokenTypeAccess
+	default:
+		return inactive, nil
+	}
+
+	return introspection(tok, tokenType), nil
+}
+
+// tokenType returns the type of token t. Refresh tokens issued before token
+// types were introduced are recognized by their lifetime which is why it must
+// differ from the lifetime of access tokens.
+func (s *authService) tokenType(t *jwt.Token) string {
+	if t.Type == "" && t.Duration == s.refTokDur {
+		return jwt.TypeRefresh
+	}
+	return t.Type
+}
+
+// introspection describes active token tok of tokenType.
+func introspection(tok *jwt.Token, tokenType string) *Introspection {
+	return &Introspection{
+		Active:    true,
+		TokenType: tokenType,
+		ID:        tok.ID,
+		Subject:   tok.Subject,
+		Username:  tok.Name,
+		Class:     tok.Class,
+		Admin:     tok.Admin,
+		Issuer:    tok.Issuer,
+		Audience:  tok.Audience,
+		IssuedAt:  tok.IssuedAt,
+		ExpiresAt: tok.ExpiresAt,
+		Scope:     strings.Join(tok.Scope, " "),
+		ClientID:  stringClaim(tok, claimClientID),
+	}
+}
+
+// Revoke revokes a refresh or access token issued to the client with clientID
+// as defined by RFC 7009. Revoking a refresh token ends its session while
+// access tokens are added to the denylist, if there is one, until they expire.
+// Tokens that are invalid, have already been revoked or were issued to another
+// client are ignored. The tokens of Login and personal access tokens are never
+// issued to a client so they are ignored too and can only be revoked with
+// Logout and RevokePersonalToken.
+func (s *authService) Revoke(token, clientID string) error {
+	if isPersonalToken(token) {
+		return nil
+	}
+	tok, valid, err := s.keys.Decode(token)
+	if err != nil {
+		if _, ok := err.(*jwt.Error); ok {
+			return nil
+		}
+		return err
+	}
+	if !valid || tok.Issuer != s.issuer {
+		return nil
+	}
+	if stringClaim(tok, claimClientID) != clientID {
+		return nil
+	}
+
+	switch s.tokenType(tok) {
+	case jwt.TypeRefresh:
+		return s.revokeRefreshToken(tok)
+	case jwt.TypeAccess:
+		// Access tokens issued before they had an ID cannot be denied.
+		if tok.ID == "" || s.denylist == nil {
+			return nil
+		}
+		if err := s.denylist.DenyToken(tok.ID, tok.ExpiresAt); err != nil {
+			return fmt.Errorf("deny token: %v", err)
+		}
+	}
+	return nil
+}
+
+// claimClientID is the claim that identifies the client a token was issued
+// to as defined by RFC 9068.
+const claimClientID = "client_id"
+
+// IsClientToken reports whether t was issued to a client through one of the
+// OAuth grants rather than by Login to Monban's own applications.
+func IsClientToken(t *jwt.Token) bool {
+	return stringClaim(t, claimClientID) != ""
+}
+
+// stringClaim returns the private claim name of token t if it is a string.
+func stringClaim(t *jwt.Token, name string) string {
+	v, _ := t.Claims[name].(string)
+	return v
+}
@@ -0,0 +1,142 @@
+// Package mail implements monban.Mailer to send emails over SMTP or to keep
+// them in a file or in memory for development and tests.
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SMTP sends emails through an SMTP server.
+type SMTP struct {
+	// Addr is the address of the SMTP server, for example
+	// "smtp.example.com:587".
+	Addr string
+	// From is the address the emails are sent from.
+	From string
+	// Auth authenticates with the SMTP server. If nil, no authentication is
+	// done.
+	Auth smtp.Auth
+}
+
+// NewSMTP returns an SMTP mailer that sends emails from the from address
+// through the server at addr. If username is not empty, PLAIN authentication
+// is used which the server only accepts over TLS.
+func NewSMTP(addr, from, username, password string) *SMTP {
+	m := &SMTP{Addr: addr, From: from}
+	if username != "" {
+		host := addr
+		if i := strings.LastIndex(addr, ":"); i != -1 {
+			host = addr[:i]
+		}
+		m.Auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// SendMail sends an email with subject and body to the to address.
+func (m *SMTP) SendMail(to, subject, body string) error {
+	msg, err := message(m.From, to, subject, body, time.Now())
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.Addr, m.Auth, m.From, []string{to}, msg)
+}
+
+// File appends the emails to a file instead of sending them.
+type File struct {
+	Path string
+	// From is the address the emails appear to be sent from.
+	From string
+
+	mu sync.Mutex
+}
+
+// SendMail appends an email with subject and body for the to address to the
+// file.
+func (m *File) SendMail(to, subject, body string) error {
+	msg, err := message(m.From, to, subject, body, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := os.OpenFile(m.Path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(msg, "\r\n"...)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Message is an email kept by Memory.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Memory keeps the emails in memory instead of sending them. It is safe for
+// concurrent use.
+type Memory struct {
+	mu       sync.Mutex
+	messages []*Message
+}
+
+// SendMail keeps an email with subject and body for the to address.
+func (m *Memory) SendMail(to, subject, body string) error {
+	if _, err := parseAddress(to); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, &Message{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Messages returns the emails kept so far.
+func (m *Memory) Messages() []*Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*Message(nil), m.messages...)
+}
+
+// message formats an email as defined by RFC 5322.
+func message(from, to, subject, body string, date time.Time) ([]byte, error) {
+	if _, err := parseAddress(to); err != nil {
+		return nil, err
+	}
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return buf.Bytes(), nil
+}
+
+// parseAddress checks that to is a single email address so that it cannot be
+// used to inject headers.
+func parseAddress(to string) (*mail.Address, error) {
+	if strings.ContainsAny(to, "\r\n") {
+		return nil, fmt.Errorf("mail: invalid address %q", to)
+	}
+	a, err := mail.ParseAddress(to)
+	if err != nil {
+		return nil, fmt.Errorf("mail: invalid address %q: %v", to, err)
+	}
+	return a, nil
+}
@@ -0,0 +1,76 @@
+package mail
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessage(t *testing.T) {
+	date := time.Date(2017, 3, 1, 12, 0, 0, 0, time.UTC)
+	got, err := message("monban@example.com", "alice@example.com", "Password reset", "Hello\nalice\n", date)
+	if err != nil {
+		t.Fatal("message returned err:", err)
+	}
+	want := "From: monban@example.com\r\n" +
+		"To: alice@example.com\r\n" +
+		"Subject: Password reset\r\n" +
+		"Date: Wed, 01 Mar 2017 12:00:00 +0000\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" +
+		"Hello\r\nalice\r\n"
+	if string(got) != want {
+		t.Errorf("message = \n%q, want \n%q", got, want)
+	}
+}
+
+func TestMessage_invalidAddress(t *testing.T) {
+	for _, to := range []string{"", "alice", "alice@example.com\r\nBcc: bob@example.com"} {
+		if _, err := message("monban@example.com", to, "subject", "body", time.Now()); err == nil {
+			t.Errorf("message to %q expected to return err", to)
+		}
+	}
+}
+
+func TestFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "monban_mail_tmpfile_")
+	if err != nil {
+		t.Fatal("could not create mail temp file:", err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	m := &File{Path: f.Name(), From: "monban@example.com"}
+	if err := m.SendMail("alice@example.com", "first", "body"); err != nil {
+		t.Fatal("File.SendMail returned err:", err)
+	}
+	if err := m.SendMail("bob@example.com", "second", "body"); err != nil {
+		t.Fatal("File.SendMail returned err:", err)
+	}
+	b, err := ioutil.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal("could not read mail file:", err)
+	}
+	for _, want := range []string{"To: alice@example.com", "Subject: first", "To: bob@example.com", "Subject: second"} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("mail file does not contain %q:\n%s", want, b)
+		}
+	}
+}
+
+func TestMemory(t *testing.T) {
+	m := &Memory{}
+	if err := m.SendMail("alice@example.com", "subject", "body"); err != nil {
+		t.Fatal("Memory.SendMail returned err:", err)
+	}
+	msgs := m.Messages()
+	if len(msgs) != 1 {
+		t.Fatalf("Memory.Messages returned %d messages, want 1", len(msgs))
+	}
+	if got, want := *msgs[0], (Message{To: "alice@example.com", Subject: "subject", Body: "body"}); got != want {
+		t.Errorf("Memory.Messages()[0] = %#v, want %#v", got, want)
+	}
+}
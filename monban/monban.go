@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
 	"time"
 
 	"golang.org/x/crypto/bcrypt"
@@ -16,18 +17,55 @@ import (
 var (
 	// ErrWrongCredentials is returned when credentials do not match.
 	ErrWrongCredentials = errors.New("wrong username or password")
-	// ErrInvalidToken is returned when a refresh token is invalid.
+	// ErrInvalidToken is returned when a token is well formed but is not
+	// accepted, for example because it has been revoked. Tokens that fail to
+	// decode are reported with a *jwt.Error instead.
 	ErrInvalidToken = errors.New("invalid token")
 	// ErrNotFound is returned whenever an item does not exist in the database.
 	ErrNotFound = errors.New("item not found")
+	// ErrTokenReused is returned by the whitelist when a refresh token that
+	// has already been exchanged is presented again.
+	ErrTokenReused = errors.New("token reused")
+	// ErrInvalidAudience is returned when tokens are requested for an
+	// audience that is not allowed.
+	ErrInvalidAudience = errors.New("audience not allowed")
 )
 
 // Whitelist describes the operations needed to keep refresh tokens in a
 // "whitelist" session storage. If a refresh token exists in the storage and
 // assuming it is not expired then it is considered valid.
+//
+// Refresh tokens are rotated: RotateToken consumes a token so that it can be
+// exchanged only once and, in the same step, whitelists the next token of the
+// family along with its session. UseToken only consumes a token. Presenting a
+// consumed token again makes both return ErrTokenReused in which case the
+// whole token family should be revoked with DeleteFamily.
 type Whitelist interface {
 	GetToken(tokenID string) (*jwt.Token, error)
 	PutToken(tokenID string, t *jwt.Token) error
+	DeleteToken(tokenID string) error
+	DeleteUserTokens(subject string) error
+	UseToken(tokenID string) (*jwt.Token, error)
+	RotateToken(tokenID string, next *jwt.Token, s *Session) (*jwt.Token, error)
+	DeleteFamily(family string) error
+	PutSession(s *Session) error
+	GetSession(id string) (*Session, error)
+	ListUserTokens(subject string) ([]*Session, error)
+}
+
+// Denylist describes the storage of access tokens that have been revoked
+// before they expire. Tokens are denied by their ID, in which case the entry
+// only needs to be kept until the token expires, or, when all the tokens of a
+// user are revoked, by the generation of their subject. DenySubject moves the
+// subject to the next generation and access tokens carry the generation of
+// their subject at the time they were issued so those of earlier generations
+// are denied. Unlike the issue times of tokens, generations are not ambiguous
+// when tokens are revoked and issued again within the same second.
+type Denylist interface {
+	DenyToken(tokenID string, expiresAt int64) error
+	DenySubject(subject string) error
+	SubjectGeneration(subject string) (int64, error)
+	IsDenied(t *jwt.Token) (bool, error)
 }
 
 // Grant is the result of successful authentication and contains access and
@@ -35,14 +73,69 @@ type Whitelist interface {
 type Grant struct {
 	Access  string
 	Refresh string
+	// ExpiresIn is the lifetime of the access token.
+	ExpiresIn time.Duration
+	// IDToken is the OpenID Connect ID token, if one was requested.
+	IDToken string
+	// Scope lists the scopes granted to the access token.
+	Scope []string
 }
 
 // AuthService specifies the operations needed for authentication.
 type AuthService interface {
-	Login(username, password string) (*Grant, error)
-	Refresh(refreshToken string) (*Grant, error)
+	Login(username, password string, req *TokenRequest) (*Grant, error)
+	Refresh(refreshToken string, req *TokenRequest) (*Grant, error)
+	Logout(refreshToken string) error
+	RevokeAll(userID int64) error
+	Authenticate(accessToken string) (*jwt.Token, error)
+	Sessions(userID int64) ([]*Session, error)
+	RevokeSession(userID int64, sessionID string) error
+	RegisterClient(c *Client) (string, error)
+	AuthenticateClient(clientID, secret string) (*Client, error)
+	Introspect(token string) (*Introspection, error)
+	Revoke(token, clientID string) error
+	CheckAuthorize(req *AuthorizeRequest) (*Client, error)
+	Authorize(username, password string, req *AuthorizeRequest) (string, error)
+	ExchangeCode(c *Client, code, redirectURI, verifier string, req *TokenRequest) (*Grant, error)
+	UserInfo(accessToken *jwt.Token) (*User, error)
+	ClientCredentials(c *Client, scope string) (*Grant, error)
+	RequestDeviceCode(c *Client, scope string) (*DeviceAuthorization, error)
+	CheckUserCode(userCode string) (*Client, error)
+	ApproveDevice(username, password, userCode string) error
+	DenyDevice(username, password, userCode string) error
+	PollDevice(c *Client, deviceCode string, req *TokenRequest) (*Grant, error)
+	CreatePersonalToken(userID int64, name string, scopes []string, expiresAt time.Time) (*PersonalToken, string, error)
+	PersonalTokens(userID int64) ([]*PersonalToken, error)
+	RevokePersonalToken(userID int64, id string) error
+	ListUsers(f *UserFilter) ([]*User, error)
+	UserByID(id int64) (*User, error)
+	UserByEmail(email string) (*User, error)
+	CreateUser(u *User) error
+	UpdateUser(u *User, password string) error
+	DeleteUser(id int64) error
+	ChangePassword(userID int64, oldPassword, newPassword string, req *TokenRequest) (*Grant, error)
+	PasswordResetEnabled() bool
+	RequestPasswordReset(email string) error
+	ResetPassword(token, newPassword string) error
 }
 
+// TokenRequest describes the client that asks for new tokens.
+type TokenRequest struct {
+	IP        string
+	UserAgent string
+	// Audience lists the services the access token is intended for. If
+	// empty, the access token is only intended for Monban itself.
+	Audience []string
+	// ClientID identifies the OAuth client that asks for the tokens, if
+	// any. The tokens are bound to the client.
+	ClientID string
+	// Scope lists the requested scopes. If empty, all the scopes the user is
+	// allowed are requested or, when refreshing, the scopes of the refresh
+	// token.
+	Scope []string
+}
+
+// User is a Monban user.
 type User struct {
 	ID      int64
 	Name    string
@@ -54,47 +147,127 @@ type User struct {
 	Joined  time.Time
 }
 
+// classBanned is the class given to banned users.
+const classBanned = "banned"
+
+// Banned reports whether the user has been banned.
+func (u *User) Banned() bool {
+	return u.Class == classBanned
+}
+
 // UserStore specifies the operations needed for storing and retrieving Monban
-// users.
+// users. UpdateUser changes the name, email, class and admin status of a user
+// but never the password which is changed by UpdatePassword. GetUserByEmail
+// returns the oldest user with the email as emails are not unique. All of them
+// return ErrNotFound for unknown users.
 type UserStore interface {
 	CreateUser(u *User) error
 	GetUser(name string) (*User, error)
+	GetUserByID(id int64) (*User, error)
+	GetUserByEmail(email string) (*User, error)
+	UpdateUser(u *User) error
+	UpdatePassword(id int64, password string) error
+	DeleteUser(id int64) error
+	ListUsers(f *UserFilter) ([]*User, error)
 }
 
 type authService struct {
 	users     UserStore
+	clients   ClientStore
+	tokens    PersonalTokenStore
 	shimmie   shimmie.Store
-	secret    string
+	keys      *jwt.KeySet
 	whitelist Whitelist
+	denylist  Denylist
+	codes     CodeStore
+	devices   DeviceStore
+	resets    ResetStore
+	mailer    Mailer
 	accTokDur time.Duration
 	refTokDur time.Duration
 	issuer    string
+	audiences []string
+	scopes    ScopePolicy
+}
+
+// Config holds the stores and settings of an AuthService.
+type Config struct {
+	Users          UserStore
+	Clients        ClientStore
+	PersonalTokens PersonalTokenStore
+	Shimmie        shimmie.Store
+	Whitelist      Whitelist
+	// Denylist is optional. Without one, access tokens cannot be revoked
+	// and stay valid until they expire.
+	Denylist Denylist
+	Codes    CodeStore
+	Devices  DeviceStore
+	Resets   ResetStore
+	// Mailer is optional. Without one, users cannot reset their passwords.
+	Mailer               Mailer
+	AccessTokenDuration  time.Duration
+	RefreshTokenDuration time.Duration
+	Issuer               string
+	// Audiences lists the services that access tokens can be requested for
+	// besides the issuer itself.
+	Audiences []string
+	// Scopes decides which scopes the users of each class can be granted.
+	Scopes ScopePolicy
+	// Keys is the key set used to sign and verify tokens.
+	Keys *jwt.KeySet
 }
 
-// NewAuthService should be used for creating a new AuthService by providing a
-// shimmie Store and a secret.
-func NewAuthService(
-	userStore UserStore,
-	shimmieDB shimmie.Store,
-	wl Whitelist,
-	accTokDur time.Duration,
-	refTokDur time.Duration,
-	issuer string,
-	secret string,
-) AuthService {
+// NewAuthService should be used for creating a new AuthService from the
+// stores and settings of c.
+func NewAuthService(c Config) AuthService {
 	s := &authService{
-		users:     userStore,
-		shimmie:   shimmieDB,
-		secret:    secret,
-		whitelist: wl,
-		accTokDur: accTokDur,
-		refTokDur: refTokDur,
-		issuer:    issuer,
+		users:     c.Users,
+		clients:   c.Clients,
+		tokens:    c.PersonalTokens,
+		shimmie:   c.Shimmie,
+		keys:      c.Keys,
+		whitelist: c.Whitelist,
+		denylist:  c.Denylist,
+		codes:     c.Codes,
+		devices:   c.Devices,
+		resets:    c.Resets,
+		mailer:    c.Mailer,
+		accTokDur: c.AccessTokenDuration,
+		refTokDur: c.RefreshTokenDuration,
+		issuer:    c.Issuer,
+		audiences: c.Audiences,
+		scopes:    c.Scopes,
 	}
 	return s
 }
 
-func (s *authService) Login(username, password string) (*Grant, error) {
+func (s *authService) Login(username, password string, req *TokenRequest) (*Grant, error) {
+	if err := s.checkAudience(req); err != nil {
+		return nil, err
+	}
+	u, err := s.authenticateUser(username, password)
+	if err != nil {
+		return nil, err
+	}
+	if u.Banned() {
+		return nil, ErrWrongCredentials
+	}
+	scopes, err := s.grantScopes(u, nil, requestScope(req))
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := s.createTokens(u, nil, "", scopes, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// authenticateUser verifies the credentials of a user. Users that exist only
+// in shimmie are migrated on their first successful login.
+func (s *authService) authenticateUser(username, password string) (*User, error) {
 	if username == "" || password == "" {
 		return nil, ErrWrongCredentials
 	}
@@ -125,13 +298,7 @@ func (s *authService) Login(username, password string) (*Grant, error) {
 	if err := bcrypt.CompareHashAndPassword([]byte(u.Pass), []byte(password)); err != nil {
 		return nil, ErrWrongCredentials
 	}
-
-	token, err := s.createTokens()
-	if err != nil {
-		return nil, err
-	}
-
-	return token, nil
+	return u, nil
 }
 
 func (s *authService) migrateUser(username, password string) error {
@@ -161,25 +328,269 @@ func (s *authService) migrateUser(username, password string) error {
 	return nil
 }
 
-func (s *authService) Refresh(refreshToken string) (*Grant, error) {
-	if refreshToken == "" {
+func (s *authService) Refresh(refreshToken string, req *TokenRequest) (*Grant, error) {
+	if err := s.checkAudience(req); err != nil {
+		return nil, err
+	}
+	tok, err := s.decodeRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	if !narrows(requestScope(req), tok.Scope) {
+		return nil, ErrInvalidScope
+	}
+	// Tokens issued to a client can only be refreshed by that client.
+	if stringClaim(tok, claimClientID) != requestClientID(req) {
+		return nil, ErrInvalidToken
+	}
+	// Check the token before consuming it so that a token that is presented
+	// by the wrong client or fails verification is not used up, which would
+	// make its legitimate use look like reuse.
+	wltok, err := s.whitelist.GetToken(tok.ID)
+	switch err {
+	case ErrNotFound:
+		// The token is either unknown or has been used before. UseToken
+		// tells the two apart.
+		used, err := s.whitelist.UseToken(tok.ID)
+		switch err {
+		case ErrTokenReused:
+			return nil, s.revokeReusedToken(used)
+		case ErrNotFound, nil:
+			return nil, ErrInvalidToken
+		default:
+			return nil, err
+		}
+	case nil:
+	default:
+		return nil, err
+	}
+	if !s.verifyToken(tok, wltok) {
 		return nil, ErrInvalidToken
 	}
 
-	tok, valid, err := jwt.Decode(refreshToken, []byte(s.secret))
+	// Reload the user the token was issued to so that deleted or banned users
+	// cannot keep refreshing their tokens.
+	u, err := s.subjectUser(tok.Subject)
 	if err != nil {
-		if err == jwt.ErrInvalidToken {
+		return nil, err
+	}
+	var c *Client
+	if clientID := requestClientID(req); clientID != "" {
+		c, err = s.clients.GetClient(clientID)
+		switch err {
+		case ErrNotFound:
 			return nil, ErrInvalidToken
+		case nil:
+		default:
+			return nil, fmt.Errorf("get client: %v", err)
+		}
+	}
+	scopes := s.refreshScopes(u, c, requestScope(req), tok.Scope)
+
+	// The token is consumed only once the new tokens are ready, together
+	// with storing them, so that a failure does not end the session.
+	token, err := s.createTokens(u, tok, "", scopes, req)
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// revokeReusedToken revokes the family of refresh token t which has been used
+// before. That means that it was most likely stolen so revoking the whole
+// family ends the session for both the legitimate user and the attacker. It
+// returns ErrInvalidToken unless the revocation fails.
+func (s *authService) revokeReusedToken(t *jwt.Token) error {
+	if err := s.whitelist.DeleteFamily(tokenFamily(t)); err != nil {
+		return fmt.Errorf("delete token family: %v", err)
+	}
+	return ErrInvalidToken
+}
+
+// Logout removes the token family of refreshToken from the whitelist which
+// ends the session it belongs to.
+func (s *authService) Logout(refreshToken string) error {
+	tok, err := s.checkRefreshToken(refreshToken)
+	if err != nil {
+		return err
+	}
+	return s.revokeRefreshToken(tok)
+}
+
+// revokeRefreshToken removes the token family of refresh token t from the
+// whitelist.
+func (s *authService) revokeRefreshToken(t *jwt.Token) error {
+	if err := s.whitelist.DeleteFamily(tokenFamily(t)); err != nil {
+		return fmt.Errorf("delete token family: %v", err)
+	}
+	// Tokens issued before families were introduced are not indexed.
+	if err := s.whitelist.DeleteToken(t.ID); err != nil {
+		return fmt.Errorf("delete token: %v", err)
+	}
+	return nil
+}
+
+// tokenFamily returns the family of refresh token t. Tokens issued before
+// rotation was introduced have no family and are treated as the first token
+// of their own family.
+func tokenFamily(t *jwt.Token) string {
+	if t.Family == "" {
+		return t.ID
+	}
+	return t.Family
+}
+
+// RevokeAll ends all of the user's sessions and deletes the user's personal
+// access tokens.
+func (s *authService) RevokeAll(userID int64) error {
+	if err := s.endSessions(userID); err != nil {
+		return err
+	}
+	if err := s.tokens.DeletePersonalTokens(userID); err != nil {
+		return fmt.Errorf("delete personal tokens: %v", err)
+	}
+	return nil
+}
+
+// endSessions removes all the refresh tokens of a user from the whitelist
+// which ends all of the user's sessions. The access tokens that have already
+// been issued to the user are denied while the ones issued afterwards are not.
+func (s *authService) endSessions(userID int64) error {
+	subject := strconv.FormatInt(userID, 10)
+	if err := s.whitelist.DeleteUserTokens(subject); err != nil {
+		return fmt.Errorf("delete user tokens: %v", err)
+	}
+	if s.denylist != nil {
+		if err := s.denylist.DenySubject(subject); err != nil {
+			return fmt.Errorf("deny subject: %v", err)
 		}
+	}
+	return nil
+}
+
+// generation returns the current revocation generation of subject which is
+// carried by the access tokens issued to it.
+func (s *authService) generation(subject string) (int64, error) {
+	if s.denylist == nil {
+		return 0, nil
+	}
+	gen, err := s.denylist.SubjectGeneration(subject)
+	if err != nil {
+		return 0, fmt.Errorf("get subject generation: %v", err)
+	}
+	return gen, nil
+}
+
+// Authenticate decodes and validates an access token. Personal access tokens
+// are accepted too and are turned into an equivalent access token.
+func (s *authService) Authenticate(accessToken string) (*jwt.Token, error) {
+	if accessToken == "" {
+		return nil, ErrInvalidToken
+	}
+	if isPersonalToken(accessToken) {
+		return s.authenticatePersonalToken(accessToken)
+	}
+	tok, valid, err := s.keys.Decode(accessToken)
+	if err != nil {
+		// The *jwt.Error tells why the token was rejected.
 		return nil, err
 	}
 	if !valid {
 		return nil, ErrInvalidToken
 	}
+	// Refresh and ID tokens are signed with the same keys and must not be
+	// mistaken for access tokens.
+	if tok.Issuer != s.issuer || tok.Type != jwt.TypeAccess {
+		return nil, ErrInvalidToken
+	}
+	if err := jwt.VerifyAudience(tok, s.issuer); err != nil {
+		return nil, err
+	}
+	denied, err := s.denied(tok)
+	if err != nil {
+		return nil, err
+	}
+	if denied {
+		return nil, ErrInvalidToken
+	}
+	return tok, nil
+}
+
+// denied reports whether access token t has been revoked.
+func (s *authService) denied(t *jwt.Token) (bool, error) {
+	if s.denylist == nil {
+		return false, nil
+	}
+	denied, err := s.denylist.IsDenied(t)
+	if err != nil {
+		return false, fmt.Errorf("check denylist: %v", err)
+	}
+	return denied, nil
+}
+
+// checkAudience makes sure that the requested audiences are allowed.
+func (s *authService) checkAudience(req *TokenRequest) error {
+	if req == nil {
+		return nil
+	}
+	for _, aud := range req.Audience {
+		if !s.allowedAudience(aud) {
+			return ErrInvalidAudience
+		}
+	}
+	return nil
+}
+
+func (s *authService) allowedAudience(aud string) bool {
+	if aud == s.issuer {
+		return true
+	}
+	for _, a := range s.audiences {
+		if a == aud {
+			return true
+		}
+	}
+	return false
+}
+
+// requestClientID returns the ID of the client that sent req, if any.
+func requestClientID(req *TokenRequest) string {
+	if req == nil {
+		return ""
+	}
+	return req.ClientID
+}
+
+// requestScope returns the scopes requested by req, if any.
+func requestScope(req *TokenRequest) []string {
+	if req == nil {
+		return nil
+	}
+	return req.Scope
+}
+
+// accessAudience returns the audience of the access token created for req.
+func (s *authService) accessAudience(req *TokenRequest) []string {
+	if req == nil || len(req.Audience) == 0 {
+		return []string{s.issuer}
+	}
+	return req.Audience
+}
+
+// checkRefreshToken decodes refreshToken and makes sure it is valid and
+// exists in the whitelist.
+func (s *authService) checkRefreshToken(refreshToken string) (*jwt.Token, error) {
+	tok, err := s.decodeRefreshToken(refreshToken)
+	if err != nil {
+		return nil, err
+	}
 
 	// Check that token exists in whitelist.
 	wltok, err := s.whitelist.GetToken(tok.ID)
 	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrInvalidToken
+		}
 		return nil, err
 	}
 
@@ -187,19 +598,36 @@ func (s *authService) Refresh(refreshToken string) (*Grant, error) {
 	if !ok {
 		return nil, ErrInvalidToken
 	}
+	return tok, nil
+}
+
+// decodeRefreshToken decodes refreshToken and makes sure its signature and
+// expiration are valid.
+func (s *authService) decodeRefreshToken(refreshToken string) (*jwt.Token, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidToken
+	}
 
-	token, err := s.createTokens()
+	tok, valid, err := s.keys.Decode(refreshToken)
 	if err != nil {
+		// The *jwt.Error tells why the token was rejected.
 		return nil, err
 	}
-	return token, nil
+	if !valid {
+		return nil, ErrInvalidToken
+	}
+	return tok, nil
 }
 
 func (s *authService) verifyToken(t, storedToken *jwt.Token) bool {
 	if t.Issuer != s.issuer {
 		return false
 	}
-	if t.Duration != s.refTokDur {
+	if s.tokenType(t) != jwt.TypeRefresh {
+		return false
+	}
+	// Refresh tokens issued before audiences were introduced have none.
+	if len(t.Audience) != 0 && !t.HasAudience(s.issuer) {
 		return false
 	}
 	if reflect.DeepEqual(t, storedToken) {
@@ -208,7 +636,38 @@ func (s *authService) verifyToken(t, storedToken *jwt.Token) bool {
 	return false
 }
 
-func (s *authService) createTokens() (*Grant, error) {
+// subjectUser returns the user identified by the subject of a token.
+func (s *authService) subjectUser(subject string) (*User, error) {
+	id, err := strconv.ParseInt(subject, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	u, err := s.users.GetUserByID(id)
+	switch err {
+	case ErrNotFound:
+		return nil, ErrInvalidToken
+	case nil:
+	default:
+		return nil, fmt.Errorf("get user by id: %v", err)
+	}
+	if u.Banned() {
+		return nil, ErrInvalidToken
+	}
+	return u, nil
+}
+
+// userSubject returns the token subject that identifies user u.
+func userSubject(u *User) string {
+	return strconv.FormatInt(u.ID, 10)
+}
+
+// createTokens creates a new access and refresh token pair for user u that
+// grants the given scopes. If prev is not nil, it is the refresh token that
+// is being exchanged. It is consumed and the new refresh token joins its
+// family at once so that the session cannot end up without a token. A nil
+// prev starts a new family, named family if it is not empty. The session of
+// the family is updated with the details of req.
+func (s *authService) createTokens(u *User, prev *jwt.Token, family string, scopes []string, req *TokenRequest) (*Grant, error) {
 	// Create CSRF token.
 	// TODO(jin): Is CSRF token needed?
 	csrfToken, err := csrf.NewToken()
@@ -219,18 +678,36 @@ func (s *authService) createTokens() (*Grant, error) {
 	// get current time
 	now := time.Now()
 
+	// Tokens issued to a client carry its ID.
+	var claims map[string]interface{}
+	if clientID := requestClientID(req); clientID != "" {
+		claims = map[string]interface{}{claimClientID: clientID}
+	}
+
 	// Create Access token.
-	// TODO(jin): Specify claims.
-	userID := jwt.NewUUID()
+	userID := userSubject(u)
+	gen, err := s.generation(userID)
+	if err != nil {
+		return nil, err
+	}
 	accessToken := &jwt.Token{
-		Subject:   userID,
-		Issuer:    s.issuer,
-		Duration:  s.accTokDur,
-		CSRF:      csrfToken,
-		ExpiresAt: now.Add(s.accTokDur).Unix(),
-		IssuedAt:  now.Unix(),
+		Type:       jwt.TypeAccess,
+		ID:         jwt.NewUUID(),
+		Subject:    userID,
+		Generation: gen,
+		Name:       u.Name,
+		Class:      u.Class,
+		Admin:      u.Admin,
+		Issuer:     s.issuer,
+		Audience:   s.accessAudience(req),
+		Scope:      scopes,
+		Duration:   s.accTokDur,
+		CSRF:       csrfToken,
+		ExpiresAt:  now.Add(s.accTokDur).Unix(),
+		IssuedAt:   now.Unix(),
+		Claims:     claims,
 	}
-	signedAccessToken, err := jwt.Encode(accessToken, []byte(s.secret))
+	signedAccessToken, err := s.keys.Encode(accessToken)
 	if err != nil {
 		return nil, fmt.Errorf("access token creation failed: %v", err)
 	}
@@ -238,28 +715,61 @@ func (s *authService) createTokens() (*Grant, error) {
 	// Create Refresh token.
 	// TODO(jin): Maybe use simple token?
 	refreshTokenID := jwt.NewUUID()
+	switch {
+	case prev != nil:
+		family = tokenFamily(prev)
+	case family == "":
+		family = refreshTokenID
+	}
 	refreshToken := &jwt.Token{
+		Type:      jwt.TypeRefresh,
 		ID:        refreshTokenID,
+		Family:    family,
 		Subject:   userID,
 		Issuer:    s.issuer,
+		Audience:  []string{s.issuer},
+		Scope:     scopes,
 		Duration:  s.refTokDur,
 		CSRF:      csrfToken,
 		ExpiresAt: now.Add(s.refTokDur).Unix(),
 		IssuedAt:  now.Unix(),
+		Claims:    claims,
 	}
-	signedRefreshToken, err := jwt.Encode(refreshToken, []byte(s.secret))
+	signedRefreshToken, err := s.keys.Encode(refreshToken)
 	if err != nil {
 		return nil, fmt.Errorf("refresh token creation failed: %v", err)
 	}
 
 	// TODO(jin): Store refreshTokenID or simple token on cache/redis/db.
-	if err := s.whitelist.PutToken(refreshToken.ID, refreshToken); err != nil {
+	sess, err := s.nextSession(refreshToken, req)
+	if err != nil {
 		return nil, err
 	}
+	if prev == nil {
+		if err := s.whitelist.PutToken(refreshToken.ID, refreshToken); err != nil {
+			return nil, err
+		}
+		if err := s.whitelist.PutSession(sess); err != nil {
+			return nil, fmt.Errorf("put session: %v", err)
+		}
+	} else {
+		used, err := s.whitelist.RotateToken(prev.ID, refreshToken, sess)
+		switch err {
+		case ErrTokenReused:
+			return nil, s.revokeReusedToken(used)
+		case ErrNotFound:
+			return nil, ErrInvalidToken
+		case nil:
+		default:
+			return nil, err
+		}
+	}
 
 	grant := &Grant{
-		Access:  signedAccessToken,
-		Refresh: signedRefreshToken,
+		Access:    signedAccessToken,
+		Refresh:   signedRefreshToken,
+		ExpiresIn: s.accTokDur,
+		Scope:     scopes,
 	}
 	return grant, nil
 }
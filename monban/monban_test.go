@@ -0,0 +1,189 @@
+package monban_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/monbantest"
+)
+
+func TestRefresh(t *testing.T) {
+	tests := []struct {
+		name string
+		// before runs after login and returns the refresh token to present
+		// along with tokens of the same family that must be unusable
+		// afterwards.
+		before func(t *testing.T, auth monban.AuthService, u *monban.User, login *monban.Grant) (string, []string)
+		want   error
+	}{
+		{
+			name: "first token",
+			before: func(t *testing.T, auth monban.AuthService, u *monban.User, login *monban.Grant) (string, []string) {
+				return login.Refresh, nil
+			},
+		},
+		{
+			name: "rotated token",
+			before: func(t *testing.T, auth monban.AuthService, u *monban.User, login *monban.Grant) (string, []string) {
+				g, err := auth.Refresh(login.Refresh, nil)
+				if err != nil {
+					t.Fatal("Refresh failed:", err)
+				}
+				if g.Refresh == login.Refresh {
+					t.Fatal("Refresh returned the same refresh token")
+				}
+				return g.Refresh, []string{login.Refresh}
+			},
+		},
+		{
+			name: "reused token revokes family",
+			before: func(t *testing.T, auth monban.AuthService, u *monban.User, login *monban.Grant) (string, []string) {
+				g, err := auth.Refresh(login.Refresh, nil)
+				if err != nil {
+					t.Fatal("Refresh failed:", err)
+				}
+				return login.Refresh, []string{g.Refresh}
+			},
+			want: monban.ErrInvalidToken,
+		},
+		{
+			name: "after RevokeAll",
+			before: func(t *testing.T, auth monban.AuthService, u *monban.User, login *monban.Grant) (string, []string) {
+				g, err := auth.Refresh(login.Refresh, nil)
+				if err != nil {
+					t.Fatal("Refresh failed:", err)
+				}
+				if err := auth.RevokeAll(u.ID); err != nil {
+					t.Fatal("RevokeAll failed:", err)
+				}
+				return g.Refresh, nil
+			},
+			want: monban.ErrInvalidToken,
+		},
+	}
+	for _, tt := range tests {
+		auth, u, teardown := monbantest.Setup(t, monban.Config{})
+		login, err := auth.Login("alice", monbantest.Password, nil)
+		if err != nil {
+			teardown()
+			t.Fatal("Login failed:", err)
+		}
+		token, stale := tt.before(t, auth, u, login)
+
+		g, err := auth.Refresh(token, nil)
+		if err != tt.want {
+			t.Errorf("%s: Refresh returned err %v, want %v", tt.name, err, tt.want)
+		}
+		if err == nil {
+			if _, err := auth.Authenticate(g.Access); err != nil {
+				t.Errorf("%s: Authenticate of refreshed access token returned err: %v", tt.name, err)
+			}
+		}
+		for _, s := range stale {
+			if _, err := auth.Refresh(s, nil); err != monban.ErrInvalidToken {
+				t.Errorf("%s: Refresh of stale token returned err %v, want %v", tt.name, err, monban.ErrInvalidToken)
+			}
+		}
+		teardown()
+	}
+}
+
+func TestRevokeAll(t *testing.T) {
+	auth, u, teardown := monbantest.Setup(t, monban.Config{})
+	defer teardown()
+
+	old, err := auth.Login("alice", monbantest.Password, nil)
+	if err != nil {
+		t.Fatal("Login failed:", err)
+	}
+	_, personal, err := auth.CreatePersonalToken(u.ID, "ci", []string{"posts:read"}, time.Time{})
+	if err != nil {
+		t.Fatal("CreatePersonalToken failed:", err)
+	}
+	if err := auth.RevokeAll(u.ID); err != nil {
+		t.Fatal("RevokeAll failed:", err)
+	}
+	if _, err := auth.Authenticate(personal); err != monban.ErrInvalidToken {
+		t.Errorf("Authenticate of revoked personal token returned err %v, want %v", err, monban.ErrInvalidToken)
+	}
+	if _, err := auth.Refresh(old.Refresh, nil); err != monban.ErrInvalidToken {
+		t.Errorf("Refresh of revoked refresh token returned err %v, want %v", err, monban.ErrInvalidToken)
+	}
+	if _, err := auth.Authenticate(old.Access); err != monban.ErrInvalidToken {
+		t.Errorf("Authenticate of revoked access token returned err %v, want %v", err, monban.ErrInvalidToken)
+	}
+
+	// Tokens issued right after the revocation, most likely within the same
+	// second, must be accepted.
+	g, err := auth.Login("alice", monbantest.Password, nil)
+	if err != nil {
+		t.Fatal("Login failed:", err)
+	}
+	if _, err := auth.Authenticate(g.Access); err != nil {
+		t.Errorf("Authenticate of new access token returned err: %v", err)
+	}
+	if _, err := auth.Refresh(g.Refresh, nil); err != nil {
+		t.Errorf("Refresh of new refresh token returned err: %v", err)
+	}
+}
+
+func TestClientCredentials(t *testing.T) {
+	auth, _, teardown := monbantest.Setup(t, monban.Config{})
+	defer teardown()
+
+	c := &monban.Client{ID: "booru", Name: "Booru", Scopes: []string{"posts:read"}}
+	g, err := auth.ClientCredentials(c, "")
+	if err != nil {
+		t.Fatal("ClientCredentials failed:", err)
+	}
+	tok, err := auth.Authenticate(g.Access)
+	if err != nil {
+		t.Fatal("Authenticate failed:", err)
+	}
+	// The name claim is the name of a user and must not be mistaken for one.
+	if tok.Subject != c.ID || tok.Name != "" {
+		t.Errorf("ClientCredentials token has subject %q and name %q, want subject %q and no name", tok.Subject, tok.Name, c.ID)
+	}
+}
+
+func TestExchangeCode_replay(t *testing.T) {
+	auth, _, teardown := monbantest.Setup(t, monban.Config{})
+	defer teardown()
+
+	const redirectURI = "https://wiki.example.com/cb"
+	c := &monban.Client{Name: "wiki", RedirectURIs: []string{redirectURI}}
+	if _, err := auth.RegisterClient(c); err != nil {
+		t.Fatal("RegisterClient failed:", err)
+	}
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	code, err := auth.Authorize("alice", monbantest.Password, &monban.AuthorizeRequest{
+		ClientID:            c.ID,
+		RedirectURI:         redirectURI,
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	})
+	if err != nil {
+		t.Fatal("Authorize failed:", err)
+	}
+	g, err := auth.ExchangeCode(c, code, redirectURI, verifier, nil)
+	if err != nil {
+		t.Fatal("ExchangeCode failed:", err)
+	}
+	// The refresh token is rotated before the replay and the whole family
+	// must be revoked.
+	g, err = auth.Refresh(g.Refresh, &monban.TokenRequest{ClientID: c.ID})
+	if err != nil {
+		t.Fatal("Refresh failed:", err)
+	}
+
+	if _, err := auth.ExchangeCode(c, code, redirectURI, verifier, nil); err != monban.ErrInvalidGrant {
+		t.Errorf("ExchangeCode of used code returned err %v, want %v", err, monban.ErrInvalidGrant)
+	}
+	if _, err := auth.Refresh(g.Refresh, &monban.TokenRequest{ClientID: c.ID}); err != monban.ErrInvalidToken {
+		t.Errorf("Refresh after code replay returned err %v, want %v", err, monban.ErrInvalidToken)
+	}
+}
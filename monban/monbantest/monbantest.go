@@ -0,0 +1,294 @@
+// Package monbantest provides in-memory implementations of the stores of
+// Monban for use in tests. Passwords and secrets are hashed with the lowest
+// bcrypt cost to keep the tests fast.
+package monbantest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/shimmie"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// UserStore is an in-memory monban.UserStore. Users are given consecutive IDs
+// starting at 1.
+type UserStore struct {
+	mu     sync.Mutex
+	users  map[int64]*monban.User
+	lastID int64
+}
+
+// NewUserStore returns an empty UserStore.
+func NewUserStore() *UserStore {
+	return &UserStore{users: make(map[int64]*monban.User)}
+}
+
+func (s *UserStore) CreateUser(u *monban.User) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(u.Pass), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastID++
+	c := *u
+	c.ID = s.lastID
+	c.Pass = string(hash)
+	c.Created = time.Now()
+	if c.Joined.IsZero() {
+		c.Joined = c.Created
+	}
+	s.users[c.ID] = &c
+	return nil
+}
+
+// find returns a copy of the first user, by ID, that match reports true for.
+func (s *UserStore) find(match func(u *monban.User) bool) (*monban.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, u := range s.sorted() {
+		if match(u) {
+			c := *u
+			return &c, nil
+		}
+	}
+	return nil, monban.ErrNotFound
+}
+
+// sorted returns the users ordered by ID. The caller must hold s.mu.
+func (s *UserStore) sorted() []*monban.User {
+	users := make([]*monban.User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+	return users
+}
+
+func (s *UserStore) GetUser(name string) (*monban.User, error) {
+	return s.find(func(u *monban.User) bool { return u.Name == name })
+}
+
+func (s *UserStore) GetUserByID(id int64) (*monban.User, error) {
+	return s.find(func(u *monban.User) bool { return u.ID == id })
+}
+
+func (s *UserStore) GetUserByEmail(email string) (*monban.User, error) {
+	return s.find(func(u *monban.User) bool { return u.Email == email })
+}
+
+func (s *UserStore) UpdateUser(u *monban.User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.users[u.ID]
+	if !ok {
+		return monban.ErrNotFound
+	}
+	old.Name, old.Email, old.Class, old.Admin = u.Name, u.Email, u.Class, u.Admin
+	return nil
+}
+
+func (s *UserStore) UpdatePassword(id int64, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return monban.ErrNotFound
+	}
+	u.Pass = string(hash)
+	return nil
+}
+
+func (s *UserStore) DeleteUser(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[id]; !ok {
+		return monban.ErrNotFound
+	}
+	delete(s.users, id)
+	return nil
+}
+
+func (s *UserStore) ListUsers(f *monban.UserFilter) ([]*monban.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var users []*monban.User
+	for _, u := range s.sorted() {
+		if f.Class != "" && u.Class != f.Class ||
+			f.Admin != nil && u.Admin != *f.Admin ||
+			!strings.HasPrefix(u.Name, f.NamePrefix) {
+			continue
+		}
+		c := *u
+		users = append(users, &c)
+	}
+	if f.Offset >= len(users) {
+		return nil, nil
+	}
+	users = users[f.Offset:]
+	if f.Limit > 0 && len(users) > f.Limit {
+		users = users[:f.Limit]
+	}
+	return users, nil
+}
+
+// ClientStore is an in-memory monban.ClientStore.
+type ClientStore struct {
+	mu      sync.Mutex
+	clients map[string]*monban.Client
+}
+
+// NewClientStore returns an empty ClientStore.
+func NewClientStore() *ClientStore {
+	return &ClientStore{clients: make(map[string]*monban.Client)}
+}
+
+func (s *ClientStore) CreateClient(c *monban.Client) error {
+	cc := *c
+	if c.Secret != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(c.Secret), bcrypt.MinCost)
+		if err != nil {
+			return err
+		}
+		cc.Secret = string(hash)
+	}
+	cc.Created = time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clients[c.ID] = &cc
+	return nil
+}
+
+func (s *ClientStore) GetClient(id string) (*monban.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[id]
+	if !ok {
+		return nil, monban.ErrNotFound
+	}
+	cc := *c
+	return &cc, nil
+}
+
+// PersonalTokenStore is an in-memory monban.PersonalTokenStore.
+type PersonalTokenStore struct {
+	mu     sync.Mutex
+	tokens map[string]*monban.PersonalToken
+}
+
+// NewPersonalTokenStore returns an empty PersonalTokenStore.
+func NewPersonalTokenStore() *PersonalTokenStore {
+	return &PersonalTokenStore{tokens: make(map[string]*monban.PersonalToken)}
+}
+
+func (s *PersonalTokenStore) CreatePersonalToken(t *monban.PersonalToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := *t
+	s.tokens[t.ID] = &c
+	return nil
+}
+
+func (s *PersonalTokenStore) GetPersonalToken(hash string) (*monban.PersonalToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.Hash == hash {
+			c := *t
+			return &c, nil
+		}
+	}
+	return nil, monban.ErrNotFound
+}
+
+func (s *PersonalTokenStore) ListPersonalTokens(userID int64) ([]*monban.PersonalToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var tokens []*monban.PersonalToken
+	for _, t := range s.tokens {
+		if t.UserID == userID {
+			c := *t
+			tokens = append(tokens, &c)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created.Before(tokens[j].Created) })
+	return tokens, nil
+}
+
+func (s *PersonalTokenStore) DeletePersonalToken(userID int64, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[id]
+	if !ok || t.UserID != userID {
+		return monban.ErrNotFound
+	}
+	delete(s.tokens, id)
+	return nil
+}
+
+func (s *PersonalTokenStore) DeletePersonalTokens(userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.tokens {
+		if t.UserID == userID {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
+
+func (s *PersonalTokenStore) UsePersonalToken(id string, lastUsed time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tokens[id]; ok {
+		t.LastUsed = lastUsed
+	}
+	return nil
+}
+
+// Shimmie is a shimmie.Store without any users so that no user is ever
+// migrated from it. Its other methods panic.
+type Shimmie struct {
+	shimmie.Store
+}
+
+func (Shimmie) Verify(username, password string) (*shimmie.User, error) {
+	return nil, shimmie.ErrNotFound
+}
+
+func (Shimmie) GetUserByName(username string) (*shimmie.User, error) {
+	return nil, shimmie.ErrNotFound
+}
+
+// Mail is an email sent by a Mailer.
+type Mail struct {
+	To, Subject, Body string
+}
+
+// Mailer is a monban.Mailer that keeps the emails instead of sending them.
+type Mailer struct {
+	mu   sync.Mutex
+	sent []Mail
+}
+
+func (m *Mailer) SendMail(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, Mail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// Sent returns the emails that have been sent so far.
+func (m *Mailer) Sent() []Mail {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Mail(nil), m.sent...)
+}
@@ -0,0 +1,90 @@
+package monbantest
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/boltdb"
+)
+
+// Password is the password of the user created by Setup.
+const Password = "password"
+
+// Setup returns an AuthService configured by c along with a user named alice
+// whose password is Password. The unset stores of c are replaced by the
+// in-memory stores of this package and a temporary bolt database except for
+// the mailer. The unset token durations, issuer, scope policy and keys get
+// defaults. The returned function closes and removes the bolt database.
+func Setup(t testing.TB, c monban.Config) (monban.AuthService, *monban.User, func()) {
+	f, err := ioutil.TempFile("", "monbantest_tmpfile_")
+	if err != nil {
+		t.Fatal("could not create boltdb temp file for tests:", err)
+	}
+	db := boltdb.Open(f.Name())
+	teardown := func() {
+		db.Close()
+		if err := os.Remove(f.Name()); err != nil {
+			log.Println("could not remove boltdb temp file:", err)
+		}
+	}
+
+	if c.Users == nil {
+		c.Users = NewUserStore()
+	}
+	if c.Clients == nil {
+		c.Clients = NewClientStore()
+	}
+	if c.PersonalTokens == nil {
+		c.PersonalTokens = NewPersonalTokenStore()
+	}
+	if c.Shimmie == nil {
+		c.Shimmie = Shimmie{}
+	}
+	if c.Whitelist == nil {
+		c.Whitelist = db.Whitelist()
+	}
+	if c.Denylist == nil {
+		c.Denylist = db.Denylist()
+	}
+	if c.Codes == nil {
+		c.Codes = db.CodeStore()
+	}
+	if c.Devices == nil {
+		c.Devices = db.DeviceStore()
+	}
+	if c.Resets == nil {
+		c.Resets = db.ResetStore()
+	}
+	if c.AccessTokenDuration == 0 {
+		c.AccessTokenDuration = 15 * time.Minute
+	}
+	if c.RefreshTokenDuration == 0 {
+		c.RefreshTokenDuration = 72 * time.Hour
+	}
+	if c.Issuer == "" {
+		c.Issuer = "monban"
+	}
+	if c.Scopes == nil {
+		c.Scopes = monban.ScopePolicy{"user": {"posts:read"}}
+	}
+	if c.Keys == nil {
+		c.Keys = jwt.NewHMACKeySet([]byte("secret"))
+	}
+
+	alice := &monban.User{Name: "alice", Pass: Password, Email: "alice@example.com", Class: "user"}
+	if err := c.Users.CreateUser(alice); err != nil {
+		teardown()
+		t.Fatal("CreateUser failed:", err)
+	}
+	u, err := c.Users.GetUser("alice")
+	if err != nil {
+		teardown()
+		t.Fatal("GetUser failed:", err)
+	}
+	return monban.NewAuthService(c), u, teardown
+}
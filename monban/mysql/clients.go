@@ -0,0 +1,88 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/kusubooru/monban/monban"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func (db *MonbanDB) CreateClient(c *monban.Client) error {
+	var err error
+	hash := []byte("")
+	if c.Secret != "" {
+		hash, err = bcrypt.GenerateFromPassword([]byte(c.Secret), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("error calculating client secret hash: %v", err)
+		}
+	}
+	_, err = db.insertClient.Exec(
+		c.ID,
+		c.Name,
+		hash,
+		c.Public,
+		strings.Join(c.RedirectURIs, " "),
+		strings.Join(c.Scopes, " "),
+		strings.Join(c.Audience, " "),
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *MonbanDB) GetClient(id string) (*monban.Client, error) {
+	c := &monban.Client{}
+	var redirectURIs, scopes, audience string
+	err := db.selectClient.QueryRow(id).Scan(
+		&c.ID,
+		&c.Name,
+		&c.Secret,
+		&c.Public,
+		&redirectURIs,
+		&scopes,
+		&audience,
+		&c.Created,
+	)
+	if err == sql.ErrNoRows {
+		return nil, monban.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Redirect URIs, scopes and audiences cannot contain spaces so they
+	// are stored space separated.
+	c.RedirectURIs = strings.Fields(redirectURIs)
+	c.Scopes = strings.Fields(scopes)
+	c.Audience = strings.Fields(audience)
+	return c, nil
+}
+
+const (
+	insertClientStmt = `
+	INSERT clients
+    SET
+      id=?,
+      name=?,
+      secret=?,
+      public=?,
+      redirect_uris=?,
+      scopes=?,
+      audience=?
+	`
+	selectClientStmt = `
+	SELECT
+	  id,
+	  name,
+	  secret,
+	  public,
+	  redirect_uris,
+	  scopes,
+	  audience,
+	  created
+	FROM clients
+	WHERE id = ?
+	`
+)
@@ -0,0 +1,59 @@
+// +build db
+
+package mysql
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kusubooru/monban/monban"
+)
+
+func TestMonbanDB_CreateClient(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	c := &monban.Client{
+		ID:           "4a2ef5f2-5c4b-4c8e-9a1b-7d3c2f1e0a9b",
+		Name:         "thumbnailer",
+		Secret:       "s3cr3t",
+		RedirectURIs: []string{"https://example.com/callback", "http://localhost:8000/callback"},
+		Scopes:       []string{"posts:read", "tags:write"},
+		Audience:     []string{"booru"},
+	}
+	if err := db.CreateClient(c); err != nil {
+		t.Fatal("CreateClient failed:", err)
+	}
+
+	have, err := db.GetClient(c.ID)
+	if err != nil {
+		t.Fatal("GetClient failed:", err)
+	}
+	if got, want := have.Name, c.Name; got != want {
+		t.Errorf("GetClient Name = %s, want %s", got, want)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(have.Secret), []byte(c.Secret)); err != nil {
+		t.Errorf("GetClient Secret wrong bcrypt hash: %v", err)
+	}
+	if got, want := have.RedirectURIs, c.RedirectURIs; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetClient RedirectURIs = %q, want %q", got, want)
+	}
+	if got, want := have.Scopes, c.Scopes; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetClient Scopes = %q, want %q", got, want)
+	}
+	if got, want := have.Audience, c.Audience; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetClient Audience = %q, want %q", got, want)
+	}
+}
+
+func TestMonbanDB_GetClient_notFound(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	_, got := db.GetClient("foo")
+	if want := monban.ErrNotFound; got != want {
+		t.Fatalf("GetClient for non existing client expected %q, got %q:", want, got)
+	}
+}
@@ -9,8 +9,22 @@ import (
 type MonbanDB struct {
 	*sql.DB
 	// prepared statements
-	insertUser *sql.Stmt
-	selectUser *sql.Stmt
+	insertUser        *sql.Stmt
+	selectUser        *sql.Stmt
+	selectUserByID    *sql.Stmt
+	selectUserByEmail *sql.Stmt
+	updateUser        *sql.Stmt
+	updatePassword    *sql.Stmt
+	deleteUser        *sql.Stmt
+	insertClient      *sql.Stmt
+	selectClient      *sql.Stmt
+
+	insertPersonalToken     *sql.Stmt
+	selectPersonalToken     *sql.Stmt
+	selectPersonalTokens    *sql.Stmt
+	deletePersonalToken     *sql.Stmt
+	deletePersonalTokens    *sql.Stmt
+	updatePersonalTokenUsed *sql.Stmt
 }
 
 // OpenMonbanDB opens a new database connection with the specified driver and
@@ -48,6 +62,58 @@ func (db *MonbanDB) prepareStatements() error {
 	if err != nil {
 		return err
 	}
+	db.selectUserByID, err = db.Prepare(selectUserByIDStmt)
+	if err != nil {
+		return err
+	}
+	db.selectUserByEmail, err = db.Prepare(selectUserByEmailStmt)
+	if err != nil {
+		return err
+	}
+	db.updateUser, err = db.Prepare(updateUserStmt)
+	if err != nil {
+		return err
+	}
+	db.updatePassword, err = db.Prepare(updatePasswordStmt)
+	if err != nil {
+		return err
+	}
+	db.deleteUser, err = db.Prepare(deleteUserStmt)
+	if err != nil {
+		return err
+	}
+	db.insertClient, err = db.Prepare(insertClientStmt)
+	if err != nil {
+		return err
+	}
+	db.selectClient, err = db.Prepare(selectClientStmt)
+	if err != nil {
+		return err
+	}
+	db.insertPersonalToken, err = db.Prepare(insertPersonalTokenStmt)
+	if err != nil {
+		return err
+	}
+	db.selectPersonalToken, err = db.Prepare(selectPersonalTokenStmt)
+	if err != nil {
+		return err
+	}
+	db.selectPersonalTokens, err = db.Prepare(selectPersonalTokensStmt)
+	if err != nil {
+		return err
+	}
+	db.deletePersonalToken, err = db.Prepare(deletePersonalTokenStmt)
+	if err != nil {
+		return err
+	}
+	db.deletePersonalTokens, err = db.Prepare(deletePersonalTokensStmt)
+	if err != nil {
+		return err
+	}
+	db.updatePersonalTokenUsed, err = db.Prepare(updatePersonalTokenUsedStmt)
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -66,7 +132,24 @@ func pingDatabase(db *sql.DB) (err error) {
 }
 
 func (db *MonbanDB) Close() error {
-	for _, stmt := range []*sql.Stmt{db.insertUser, db.selectUser} {
+	stmts := []*sql.Stmt{
+		db.insertUser,
+		db.selectUser,
+		db.selectUserByID,
+		db.selectUserByEmail,
+		db.updateUser,
+		db.updatePassword,
+		db.deleteUser,
+		db.insertClient,
+		db.selectClient,
+		db.insertPersonalToken,
+		db.selectPersonalToken,
+		db.selectPersonalTokens,
+		db.deletePersonalToken,
+		db.deletePersonalTokens,
+		db.updatePersonalTokenUsed,
+	}
+	for _, stmt := range stmts {
 		if err := stmt.Close(); err != nil {
 			return err
 		}
@@ -0,0 +1,179 @@
+package mysql
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/kusubooru/monban/monban"
+)
+
+func (db *MonbanDB) CreatePersonalToken(t *monban.PersonalToken) error {
+	_, err := db.insertPersonalToken.Exec(
+		t.ID,
+		t.UserID,
+		t.Name,
+		t.Hash,
+		strings.Join(t.Scopes, " "),
+		nullTime(t.ExpiresAt),
+		t.Created,
+	)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *MonbanDB) GetPersonalToken(hash string) (*monban.PersonalToken, error) {
+	t, err := scanPersonalToken(db.selectPersonalToken.QueryRow(hash))
+	if err == sql.ErrNoRows {
+		return nil, monban.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (db *MonbanDB) ListPersonalTokens(userID int64) ([]*monban.PersonalToken, error) {
+	rows, err := db.selectPersonalTokens.Query(userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tokens []*monban.PersonalToken
+	for rows.Next() {
+		t, err := scanPersonalToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+func (db *MonbanDB) DeletePersonalToken(userID int64, id string) error {
+	res, err := db.deletePersonalToken.Exec(id, userID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return monban.ErrNotFound
+	}
+	return nil
+}
+
+func (db *MonbanDB) DeletePersonalTokens(userID int64) error {
+	if _, err := db.deletePersonalTokens.Exec(userID); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (db *MonbanDB) UsePersonalToken(id string, lastUsed time.Time) error {
+	if _, err := db.updatePersonalTokenUsed.Exec(lastUsed, id); err != nil {
+		return err
+	}
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPersonalToken(row scanner) (*monban.PersonalToken, error) {
+	t := &monban.PersonalToken{}
+	var scopes string
+	var expiresAt, lastUsed *time.Time
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.Hash,
+		&scopes,
+		&expiresAt,
+		&lastUsed,
+		&t.Created,
+	)
+	if err != nil {
+		return nil, err
+	}
+	// Scopes cannot contain spaces so they are stored space separated.
+	t.Scopes = strings.Fields(scopes)
+	if expiresAt != nil {
+		t.ExpiresAt = *expiresAt
+	}
+	if lastUsed != nil {
+		t.LastUsed = *lastUsed
+	}
+	return t, nil
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+const (
+	insertPersonalTokenStmt = `
+	INSERT personal_tokens
+    SET
+      id=?,
+      user_id=?,
+      name=?,
+      hash=?,
+      scopes=?,
+      expires_at=?,
+      created=?
+	`
+	selectPersonalTokenStmt = `
+	SELECT
+	  id,
+	  user_id,
+	  name,
+	  hash,
+	  scopes,
+	  expires_at,
+	  last_used,
+	  created
+	FROM personal_tokens
+	WHERE hash = ?
+	`
+	selectPersonalTokensStmt = `
+	SELECT
+	  id,
+	  user_id,
+	  name,
+	  hash,
+	  scopes,
+	  expires_at,
+	  last_used,
+	  created
+	FROM personal_tokens
+	WHERE user_id = ?
+	ORDER BY created
+	`
+	deletePersonalTokenStmt = `
+	DELETE FROM personal_tokens
+	WHERE id = ? AND user_id = ?
+	`
+	deletePersonalTokensStmt = `
+	DELETE FROM personal_tokens
+	WHERE user_id = ?
+	`
+	updatePersonalTokenUsedStmt = `
+	UPDATE personal_tokens
+	SET last_used = ?
+	WHERE id = ?
+	`
+)
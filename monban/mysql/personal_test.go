@@ -0,0 +1,86 @@
+// +build db
+
+package mysql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/monban"
+)
+
+func TestMonbanDB_PersonalTokens(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	u, err := db.GetUser("Anonymous")
+	if err != nil {
+		t.Fatal("GetUser failed:", err)
+	}
+	now := time.Now().Truncate(time.Second)
+	pt := &monban.PersonalToken{
+		ID:        "0c8f7d4e-1a2b-4c3d-8e9f-0a1b2c3d4e5f",
+		UserID:    u.ID,
+		Name:      "uploader",
+		Hash:      "47DEQpj8HBSa-_TImW-5JCeuQeRkm5NMpJWZG3hSuFU",
+		Scopes:    []string{"posts:write", "tags:write"},
+		ExpiresAt: now.Add(24 * time.Hour),
+		Created:   now,
+	}
+	if err := db.CreatePersonalToken(pt); err != nil {
+		t.Fatal("CreatePersonalToken failed:", err)
+	}
+
+	have, err := db.GetPersonalToken(pt.Hash)
+	if err != nil {
+		t.Fatal("GetPersonalToken failed:", err)
+	}
+	if got, want := have.Name, pt.Name; got != want {
+		t.Errorf("GetPersonalToken Name = %s, want %s", got, want)
+	}
+	if got, want := have.Scopes, pt.Scopes; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPersonalToken Scopes = %q, want %q", got, want)
+	}
+	if got, want := have.ExpiresAt, pt.ExpiresAt; !got.Equal(want) {
+		t.Errorf("GetPersonalToken ExpiresAt = %v, want %v", got, want)
+	}
+	if !have.LastUsed.IsZero() {
+		t.Errorf("GetPersonalToken LastUsed = %v, want zero time", have.LastUsed)
+	}
+
+	used := now.Add(time.Hour)
+	if err := db.UsePersonalToken(pt.ID, used); err != nil {
+		t.Fatal("UsePersonalToken failed:", err)
+	}
+	tokens, err := db.ListPersonalTokens(u.ID)
+	if err != nil {
+		t.Fatal("ListPersonalTokens failed:", err)
+	}
+	if len(tokens) != 1 {
+		t.Fatalf("ListPersonalTokens returned %d tokens, want 1", len(tokens))
+	}
+	if got := tokens[0].LastUsed; !got.Equal(used) {
+		t.Errorf("ListPersonalTokens LastUsed = %v, want %v", got, used)
+	}
+
+	if err := db.DeletePersonalToken(u.ID+1, pt.ID); err != monban.ErrNotFound {
+		t.Errorf("DeletePersonalToken of other user expected %q, got %q", monban.ErrNotFound, err)
+	}
+	if err := db.DeletePersonalToken(u.ID, pt.ID); err != nil {
+		t.Fatal("DeletePersonalToken failed:", err)
+	}
+	if _, err := db.GetPersonalToken(pt.Hash); err != monban.ErrNotFound {
+		t.Errorf("GetPersonalToken after delete expected %q, got %q", monban.ErrNotFound, err)
+	}
+
+	if err := db.CreatePersonalToken(pt); err != nil {
+		t.Fatal("CreatePersonalToken failed:", err)
+	}
+	if err := db.DeletePersonalTokens(u.ID); err != nil {
+		t.Fatal("DeletePersonalTokens failed:", err)
+	}
+	if _, err := db.GetPersonalToken(pt.Hash); err != monban.ErrNotFound {
+		t.Errorf("GetPersonalToken after DeletePersonalTokens expected %q, got %q", monban.ErrNotFound, err)
+	}
+}
@@ -10,10 +10,40 @@ func (db *MonbanDB) createTables() error {
 	if _, err := db.Exec(tableUsers); err != nil {
 		return err
 	}
+	if err := db.addEmailKey(); err != nil {
+		return err
+	}
+	if _, err := db.Exec(tableClients); err != nil {
+		return err
+	}
+	if _, err := db.Exec(tablePersonalTokens); err != nil {
+		return err
+	}
 
 	return nil
 }
 
+// addEmailKey indexes the email of the users of tables that were created
+// before the index was added to tableUsers. It does nothing if the email is
+// already indexed so it can run on every start.
+func (db *MonbanDB) addEmailKey() error {
+	var n int
+	err := db.QueryRow(`
+	SELECT COUNT(*)
+	FROM information_schema.statistics
+	WHERE table_schema = DATABASE()
+	  AND table_name = 'users'
+	  AND column_name = 'email'`).Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n != 0 {
+		return nil
+	}
+	_, err = db.Exec(`ALTER TABLE users ADD KEY email (email)`)
+	return err
+}
+
 func (db *MonbanDB) insertAnonymous() error {
 	_, err := db.GetUser("Anonymous")
 	switch err {
@@ -36,9 +66,15 @@ func (db *MonbanDB) insertAnonymous() error {
 }
 
 func (db *MonbanDB) dropSchema() error {
+	if _, err := db.Exec(`DROP TABLE personal_tokens`); err != nil {
+		return err
+	}
 	if _, err := db.Exec(`DROP TABLE users`); err != nil {
 		return err
 	}
+	if _, err := db.Exec(`DROP TABLE clients`); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -54,6 +90,33 @@ CREATE TABLE IF NOT EXISTS users (
 	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
 	joined TIMESTAMP NOT NULL DEFAULT '1971-01-01 00:00:00',
 	PRIMARY KEY (id),
-	UNIQUE KEY (name)
+	UNIQUE KEY (name),
+	KEY (email)
+)`
+	tableClients = `
+CREATE TABLE IF NOT EXISTS clients (
+	id VARCHAR(36) NOT NULL,
+	name VARCHAR(64) NOT NULL DEFAULT '',
+	secret BINARY(60) NOT NULL,
+	public BOOL NOT NULL DEFAULT FALSE,
+	redirect_uris TEXT NOT NULL,
+	scopes TEXT NOT NULL,
+	audience TEXT NOT NULL,
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id)
+)`
+	tablePersonalTokens = `
+CREATE TABLE IF NOT EXISTS personal_tokens (
+	id VARCHAR(36) NOT NULL,
+	user_id BIGINT NOT NULL,
+	name VARCHAR(64) NOT NULL DEFAULT '',
+	hash CHAR(43) NOT NULL,
+	scopes TEXT NOT NULL,
+	expires_at TIMESTAMP NULL DEFAULT NULL,
+	last_used TIMESTAMP NULL DEFAULT NULL,
+	created TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	UNIQUE KEY (hash),
+	FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
 )`
 )
@@ -3,6 +3,7 @@ package mysql
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/kusubooru/monban/monban"
@@ -70,6 +71,163 @@ func (db *MonbanDB) GetUser(name string) (*monban.User, error) {
 	return u, nil
 }
 
+func (db *MonbanDB) GetUserByID(id int64) (*monban.User, error) {
+	u := &monban.User{}
+	err := db.selectUserByID.QueryRow(id).Scan(
+		&u.ID,
+		&u.Name,
+		&u.Pass,
+		&u.Email,
+		&u.Class,
+		&u.Admin,
+		&u.Created,
+		&u.Joined,
+	)
+	if err == sql.ErrNoRows {
+		return nil, monban.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (db *MonbanDB) GetUserByEmail(email string) (*monban.User, error) {
+	u, err := scanUser(db.selectUserByEmail.QueryRow(email))
+	if err == sql.ErrNoRows {
+		return nil, monban.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (db *MonbanDB) UpdateUser(u *monban.User) error {
+	res, err := db.updateUser.Exec(
+		u.Name,
+		u.Email,
+		u.Class,
+		u.Admin,
+		u.ID,
+	)
+	if err != nil {
+		return err
+	}
+	// MySQL only counts the rows that actually changed so a user that is
+	// updated with the same values has to be looked up.
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		_, err := db.GetUserByID(u.ID)
+		return err
+	}
+	return nil
+}
+
+func (db *MonbanDB) UpdatePassword(id int64, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("error calculating password hash: %v", err)
+	}
+	res, err := db.updatePassword.Exec(hash, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return monban.ErrNotFound
+	}
+	return nil
+}
+
+func (db *MonbanDB) DeleteUser(id int64) error {
+	res, err := db.deleteUser.Exec(id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return monban.ErrNotFound
+	}
+	return nil
+}
+
+func (db *MonbanDB) ListUsers(f *monban.UserFilter) ([]*monban.User, error) {
+	var where []string
+	var args []interface{}
+	if f.Class != "" {
+		where = append(where, "class = ?")
+		args = append(args, f.Class)
+	}
+	if f.Admin != nil {
+		where = append(where, "admin = ?")
+		args = append(args, *f.Admin)
+	}
+	if f.NamePrefix != "" {
+		where = append(where, "name LIKE ?")
+		args = append(args, escapeLike(f.NamePrefix)+"%")
+	}
+	query := selectUsersStmt
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY id"
+	if f.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, f.Limit, f.Offset)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []*monban.User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func scanUser(row scanner) (*monban.User, error) {
+	u := &monban.User{}
+	err := row.Scan(
+		&u.ID,
+		&u.Name,
+		&u.Pass,
+		&u.Email,
+		&u.Class,
+		&u.Admin,
+		&u.Created,
+		&u.Joined,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
 const (
 	insertUserStmt = `
 	INSERT users
@@ -93,4 +251,61 @@ const (
 	FROM users
 	WHERE name = ?
 	`
+	selectUserByIDStmt = `
+	SELECT
+	  id,
+	  name,
+	  pass,
+	  email,
+	  class,
+	  admin,
+	  created,
+	  joined
+	FROM users
+	WHERE id = ?
+	`
+	selectUserByEmailStmt = `
+	SELECT
+	  id,
+	  name,
+	  pass,
+	  email,
+	  class,
+	  admin,
+	  created,
+	  joined
+	FROM users
+	WHERE email = ?
+	ORDER BY id
+	LIMIT 1
+	`
+	updateUserStmt = `
+	UPDATE users
+    SET
+      name=?,
+      email=?,
+      class=?,
+      admin=?
+	WHERE id = ?
+	`
+	updatePasswordStmt = `
+	UPDATE users
+	SET pass = ?
+	WHERE id = ?
+	`
+	deleteUserStmt = `
+	DELETE FROM users
+	WHERE id = ?
+	`
+	selectUsersStmt = `
+	SELECT
+	  id,
+	  name,
+	  pass,
+	  email,
+	  class,
+	  admin,
+	  created,
+	  joined
+	FROM users`
 )
@@ -3,6 +3,7 @@
 package mysql
 
 import (
+	"reflect"
 	"testing"
 	"time"
 
@@ -67,3 +68,195 @@ func TestMonbanDB_GetUser_notFound(t *testing.T) {
 		t.Fatalf("GetUser for non existing user expected %q, got %q:", got, want)
 	}
 }
+
+func TestMonbanDB_GetUserByID(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	u := &monban.User{Name: "foo", Pass: "bar"}
+	if err := db.CreateUser(u); err != nil {
+		t.Fatal("CreateUser failed:", err)
+	}
+	byName, err := db.GetUser("foo")
+	if err != nil {
+		t.Fatal("GetUser failed:", err)
+	}
+
+	have, err := db.GetUserByID(byName.ID)
+	if err != nil {
+		t.Fatal("GetUserByID failed:", err)
+	}
+	if got, want := have.Name, "foo"; got != want {
+		t.Errorf("GetUserByID(%d) Name = %s, want %s", byName.ID, got, want)
+	}
+}
+
+func TestMonbanDB_GetUserByID_notFound(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	_, got := db.GetUserByID(1000)
+	if want := monban.ErrNotFound; got != want {
+		t.Fatalf("GetUserByID for non existing user expected %q, got %q:", want, got)
+	}
+}
+
+func TestMonbanDB_UpdateUser(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	if err := db.CreateUser(&monban.User{Name: "foo", Pass: "bar"}); err != nil {
+		t.Fatal("CreateUser failed:", err)
+	}
+	u, err := db.GetUser("foo")
+	if err != nil {
+		t.Fatal("GetUser failed:", err)
+	}
+	u.Name = "baz"
+	u.Email = "baz@example.com"
+	u.Class = "moderator"
+	u.Admin = true
+	if err := db.UpdateUser(u); err != nil {
+		t.Fatal("UpdateUser failed:", err)
+	}
+	// Updating with the same values must not report the user as missing.
+	if err := db.UpdateUser(u); err != nil {
+		t.Fatal("UpdateUser with same values failed:", err)
+	}
+
+	have, err := db.GetUserByEmail("baz@example.com")
+	if err != nil {
+		t.Fatal("GetUserByEmail failed:", err)
+	}
+	if have.ID != u.ID || have.Name != u.Name || have.Class != u.Class || !have.Admin {
+		t.Errorf("GetUserByEmail = %#v, want %#v", have, u)
+	}
+	// pass
+	if err := bcrypt.CompareHashAndPassword([]byte(have.Pass), []byte("bar")); err != nil {
+		t.Errorf("UpdateUser changed password: %v", err)
+	}
+
+	u.ID = 1000
+	if err := db.UpdateUser(u); err != monban.ErrNotFound {
+		t.Errorf("UpdateUser for non existing user expected %q, got %q", monban.ErrNotFound, err)
+	}
+}
+
+func TestMonbanDB_DeleteUser(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	if err := db.CreateUser(&monban.User{Name: "foo", Pass: "bar"}); err != nil {
+		t.Fatal("CreateUser failed:", err)
+	}
+	u, err := db.GetUser("foo")
+	if err != nil {
+		t.Fatal("GetUser failed:", err)
+	}
+	if err := db.DeleteUser(u.ID); err != nil {
+		t.Fatal("DeleteUser failed:", err)
+	}
+	if _, err := db.GetUserByID(u.ID); err != monban.ErrNotFound {
+		t.Errorf("GetUserByID after delete expected %q, got %q", monban.ErrNotFound, err)
+	}
+	if err := db.DeleteUser(u.ID); err != monban.ErrNotFound {
+		t.Errorf("DeleteUser for non existing user expected %q, got %q", monban.ErrNotFound, err)
+	}
+}
+
+func TestMonbanDB_ListUsers(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	users := []*monban.User{
+		{Name: "foo", Pass: "bar", Class: "user"},
+		{Name: "foo_bar", Pass: "bar", Class: "admin", Admin: true},
+		{Name: "fooxbar", Pass: "bar", Class: "user"},
+		{Name: "baz", Pass: "bar", Class: "user"},
+	}
+	for _, u := range users {
+		if err := db.CreateUser(u); err != nil {
+			t.Fatal("CreateUser failed:", err)
+		}
+	}
+
+	yes := true
+	tests := []struct {
+		filter *monban.UserFilter
+		want   []string
+	}{
+		{&monban.UserFilter{}, []string{"Anonymous", "foo", "foo_bar", "fooxbar", "baz"}},
+		{&monban.UserFilter{NamePrefix: "foo"}, []string{"foo", "foo_bar", "fooxbar"}},
+		{&monban.UserFilter{NamePrefix: "foo_"}, []string{"foo_bar"}},
+		{&monban.UserFilter{Class: "user"}, []string{"foo", "fooxbar", "baz"}},
+		{&monban.UserFilter{Admin: &yes}, []string{"foo_bar"}},
+		{&monban.UserFilter{Class: "user", Offset: 1, Limit: 1}, []string{"fooxbar"}},
+	}
+	for _, tt := range tests {
+		have, err := db.ListUsers(tt.filter)
+		if err != nil {
+			t.Fatalf("ListUsers(%#v) failed: %v", tt.filter, err)
+		}
+		var got []string
+		for _, u := range have {
+			got = append(got, u.Name)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ListUsers(%#v) = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func TestMonbanDB_UpdatePassword(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	if err := db.CreateUser(&monban.User{Name: "foo", Pass: "bar"}); err != nil {
+		t.Fatal("CreateUser failed:", err)
+	}
+	u, err := db.GetUser("foo")
+	if err != nil {
+		t.Fatal("GetUser failed:", err)
+	}
+	if err := db.UpdatePassword(u.ID, "baz"); err != nil {
+		t.Fatal("UpdatePassword failed:", err)
+	}
+	have, err := db.GetUser("foo")
+	if err != nil {
+		t.Fatal("GetUser failed:", err)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(have.Pass), []byte("baz")); err != nil {
+		t.Errorf("GetUser Pass wrong bcrypt hash after UpdatePassword: %v", err)
+	}
+	if err := db.UpdatePassword(1000, "baz"); err != monban.ErrNotFound {
+		t.Errorf("UpdatePassword for non existing user expected %q, got %q", monban.ErrNotFound, err)
+	}
+}
+
+func TestMonbanDB_addEmailKey(t *testing.T) {
+	db := setup(t)
+	defer teardown(t, db)
+
+	// Tables created before the index was added have no index on email.
+	if _, err := db.Exec(`ALTER TABLE users DROP KEY email`); err != nil {
+		t.Fatal("dropping email key failed:", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := db.createTables(); err != nil {
+			t.Fatalf("createTables run %d failed: %v", i+1, err)
+		}
+	}
+	var n int
+	err := db.QueryRow(`
+	SELECT COUNT(*)
+	FROM information_schema.statistics
+	WHERE table_schema = DATABASE()
+	  AND table_name = 'users'
+	  AND column_name = 'email'`).Scan(&n)
+	if err != nil {
+		t.Fatal("counting email keys failed:", err)
+	}
+	if n != 1 {
+		t.Errorf("users has %d keys on email, want 1", n)
+	}
+}
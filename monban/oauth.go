@@ -0,0 +1,249 @@
+package monban
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+var (
+	// ErrInvalidClient is returned when an authorization request names a
+	// client that is not registered.
+	ErrInvalidClient = errors.New("invalid client")
+	// ErrInvalidRedirectURI is returned when an authorization request
+	// names a redirect URI that is not registered for the client.
+	ErrInvalidRedirectURI = errors.New("invalid redirect URI")
+	// ErrInvalidChallenge is returned when an authorization request does
+	// not carry an S256 PKCE code challenge.
+	ErrInvalidChallenge = errors.New("S256 code challenge required")
+	// ErrInvalidGrant is returned when an authorization code is unknown,
+	// expired, issued to another client or does not match the code
+	// verifier.
+	ErrInvalidGrant = errors.New("invalid authorization grant")
+	// ErrCodeReused is returned by CodeStore.UseCode when an authorization
+	// code has already been used.
+	ErrCodeReused = errors.New("authorization code reused")
+)
+
+// CodeStore describes the storage of the authorization codes. UseCode must
+// mark the code as used by a token family so that it can be exchanged only
+// once. Used codes are kept until they expire and UseCode returns them along
+// with ErrCodeReused so that the tokens issued for them can be revoked when
+// they are replayed. GetCode returns a code, used or not, and leaves it in
+// place. Both return ErrNotFound for unknown codes.
+type CodeStore interface {
+	PutCode(c *AuthCode) error
+	GetCode(code string) (*AuthCode, error)
+	UseCode(code, family string) (*AuthCode, error)
+}
+
+// AuthCode is an OAuth 2.0 authorization code that a client exchanges for
+// tokens once the user has authorized it.
+type AuthCode struct {
+	// Code is the SHA-256 hash of the code given to the client.
+	Code        string
+	ClientID    string
+	RedirectURI string
+	Subject     string
+	// Challenge is the S256 PKCE code challenge of the authorization
+	// request.
+	Challenge string
+	// Scope is the space separated list of the granted scopes. Scope, Nonce
+	// and AuthTime are used to create the ID token of OpenID Connect
+	// requests.
+	Scope     string
+	Nonce     string
+	AuthTime  int64
+	ExpiresAt int64
+	// Used is set once the code has been exchanged for tokens and Family
+	// is the token family they belong to.
+	Used   bool
+	Family string
+}
+
+// AuthorizeRequest is an OAuth 2.0 authorization request that asks for an
+// authorization code.
+type AuthorizeRequest struct {
+	ClientID            string
+	RedirectURI         string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	// Scope is the space separated list of the requested scopes. An ID
+	// token is issued if it contains "openid".
+	Scope string
+	// Nonce is copied to the ID token so that the client can detect
+	// replayed ID tokens.
+	Nonce string
+}
+
+// authCodeDuration is how long authorization codes can be exchanged for.
+const authCodeDuration = time.Minute
+
+// authCodeSize is the size of the authorization codes in bytes.
+const authCodeSize = 32
+
+// CheckAuthorize makes sure that an authorization request comes from a
+// registered client and redirects to one of its redirect URIs. If the request
+// has no redirect URI and the client has only one, it is used. Errors about
+// the client and the redirect URI must be shown to the user instead of
+// redirecting.
+func (s *authService) CheckAuthorize(req *AuthorizeRequest) (*Client, error) {
+	c, err := s.clients.GetClient(req.ClientID)
+	switch err {
+	case ErrNotFound:
+		return nil, ErrInvalidClient
+	case nil:
+	default:
+		return nil, fmt.Errorf("get client: %v", err)
+	}
+	if req.RedirectURI == "" && len(c.RedirectURIs) == 1 {
+		req.RedirectURI = c.RedirectURIs[0]
+	}
+	if !c.hasRedirectURI(req.RedirectURI) {
+		return nil, ErrInvalidRedirectURI
+	}
+	if req.CodeChallengeMethod != "S256" || req.CodeChallenge == "" {
+		return c, ErrInvalidChallenge
+	}
+	return c, nil
+}
+
+// Authorize authenticates the user with username and password and returns an
+// authorization code for the request. The code grants the requested scopes
+// that the user and the client are allowed.
+func (s *authService) Authorize(username, password string, req *AuthorizeRequest) (string, error) {
+	c, err := s.CheckAuthorize(req)
+	if err != nil {
+		return "", err
+	}
+	u, err := s.authenticateUser(username, password)
+	if err != nil {
+		return "", err
+	}
+	if u.Banned() {
+		return "", ErrWrongCredentials
+	}
+	scopes, err := s.grantScopes(u, c, strings.Fields(req.Scope))
+	if err != nil {
+		return "", err
+	}
+
+	code, err := randomString(authCodeSize)
+	if err != nil {
+		return "", fmt.Errorf("authorization code creation failed: %v", err)
+	}
+	now := time.Now()
+	ac := &AuthCode{
+		Code:        hashCode(code),
+		ClientID:    req.ClientID,
+		RedirectURI: req.RedirectURI,
+		Subject:     userSubject(u),
+		Challenge:   req.CodeChallenge,
+		Scope:       strings.Join(scopes, " "),
+		Nonce:       req.Nonce,
+		AuthTime:    now.Unix(),
+		ExpiresAt:   now.Add(authCodeDuration).Unix(),
+	}
+	if err := s.codes.PutCode(ac); err != nil {
+		return "", fmt.Errorf("put code: %v", err)
+	}
+	return code, nil
+}
+
+// ExchangeCode exchanges an authorization code issued to client c for tokens.
+// The redirect URI must be the one of the authorization request and the code
+// verifier must match its code challenge. An ID token is included for OpenID
+// Connect requests.
+func (s *authService) ExchangeCode(c *Client, code, redirectURI, verifier string, req *TokenRequest) (*Grant, error) {
+	if code == "" {
+		return nil, ErrInvalidGrant
+	}
+	// The code is checked before it is consumed so that a request with the
+	// wrong client, redirect URI or verifier cannot use it up.
+	ac, err := s.codes.GetCode(hashCode(code))
+	switch err {
+	case ErrNotFound:
+		return nil, ErrInvalidGrant
+	case nil:
+	default:
+		return nil, fmt.Errorf("get code: %v", err)
+	}
+	if ac.ExpiresAt < time.Now().Unix() ||
+		ac.ClientID != c.ID ||
+		ac.RedirectURI != redirectURI ||
+		!verifyChallenge(ac.Challenge, verifier) {
+		return nil, ErrInvalidGrant
+	}
+	if ac.Used {
+		return nil, s.revokeReusedCode(ac)
+	}
+	u, err := s.subjectUser(ac.Subject)
+	if err != nil {
+		return nil, ErrInvalidGrant
+	}
+
+	// Only one of concurrent exchanges of the same code gets to use it. The
+	// family of the tokens is recorded on the code so that they can be
+	// revoked if the code is replayed.
+	family := jwt.NewUUID()
+	switch used, err := s.codes.UseCode(ac.Code, family); err {
+	case ErrCodeReused:
+		return nil, s.revokeReusedCode(used)
+	case ErrNotFound:
+		return nil, ErrInvalidGrant
+	case nil:
+	default:
+		return nil, fmt.Errorf("use code: %v", err)
+	}
+	if req == nil {
+		req = new(TokenRequest)
+	}
+	req.ClientID = c.ID
+	grant, err := s.createTokens(u, nil, family, strings.Fields(ac.Scope), req)
+	if err != nil {
+		return nil, err
+	}
+	if hasScope(ac.Scope, scopeOpenID) {
+		grant.IDToken, err = s.createIDToken(u, ac, grant.Access)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return grant, nil
+}
+
+// revokeReusedCode revokes the token family issued for authorization code ac
+// which has been used before. As advised by RFC 6749, a replayed code most
+// likely leaked so the tokens issued for it are revoked. It returns
+// ErrInvalidGrant unless the revocation fails.
+func (s *authService) revokeReusedCode(ac *AuthCode) error {
+	if ac.Family != "" {
+		if err := s.whitelist.DeleteFamily(ac.Family); err != nil {
+			return fmt.Errorf("delete token family: %v", err)
+		}
+	}
+	return ErrInvalidGrant
+}
+
+// hashCode returns the hash that an authorization code is stored as.
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// verifyChallenge reports whether verifier matches the S256 PKCE code
+// challenge as defined by RFC 7636.
+func verifyChallenge(challenge, verifier string) bool {
+	if verifier == "" {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	got := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(got), []byte(challenge)) == 1
+}
@@ -0,0 +1,64 @@
+package monban
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+// scopeOpenID is the scope that turns an OAuth 2.0 authorization request into
+// an OpenID Connect authentication request.
+const scopeOpenID = "openid"
+
+// hasScope reports whether the space separated scopes contain scope.
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// createIDToken creates an OpenID Connect ID token for user u who authorized
+// the client with authorization code ac. The ID token is issued along with
+// accessToken.
+func (s *authService) createIDToken(u *User, ac *AuthCode, accessToken string) (string, error) {
+	atHash, err := jwt.AccessTokenHash(s.keys.Current().Alg, accessToken)
+	if err != nil {
+		return "", fmt.Errorf("access token hash: %v", err)
+	}
+	claims := map[string]interface{}{
+		"auth_time":          ac.AuthTime,
+		"at_hash":            atHash,
+		"preferred_username": u.Name,
+	}
+	if ac.Nonce != "" {
+		claims["nonce"] = ac.Nonce
+	}
+
+	now := time.Now()
+	idToken := &jwt.Token{
+		Type:      jwt.TypeID,
+		Subject:   userSubject(u),
+		Name:      u.Name,
+		Issuer:    s.issuer,
+		Audience:  []string{ac.ClientID},
+		ExpiresAt: now.Add(s.accTokDur).Unix(),
+		IssuedAt:  now.Unix(),
+		Claims:    claims,
+	}
+	signed, err := s.keys.Encode(idToken)
+	if err != nil {
+		return "", fmt.Errorf("ID token creation failed: %v", err)
+	}
+	return signed, nil
+}
+
+// UserInfo returns the user an access token was issued to. It returns
+// ErrInvalidToken if the user has been deleted or banned.
+func (s *authService) UserInfo(accessToken *jwt.Token) (*User, error) {
+	return s.subjectUser(accessToken.Subject)
+}
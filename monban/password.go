@@ -0,0 +1,55 @@
+package monban
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidPassword is returned when a new password is shorter than
+// minPasswordLength.
+var ErrInvalidPassword = errors.New("password too short")
+
+// minPasswordLength is the minimum length of new passwords in bytes. Users
+// migrated from Shimmie may still have shorter passwords.
+const minPasswordLength = 8
+
+// ChangePassword changes the password of the user with userID after checking
+// the old one. All of the user's sessions are ended and personal access tokens
+// deleted so that whoever knew the old password is locked out. A fresh grant is
+// returned so that the client that changed the password stays logged in.
+func (s *authService) ChangePassword(userID int64, oldPassword, newPassword string, req *TokenRequest) (*Grant, error) {
+	if err := s.checkAudience(req); err != nil {
+		return nil, err
+	}
+	if len(newPassword) < minPasswordLength {
+		return nil, ErrInvalidPassword
+	}
+	u, err := s.users.GetUserByID(userID)
+	switch err {
+	case ErrNotFound:
+		return nil, ErrWrongCredentials
+	case nil:
+	default:
+		return nil, fmt.Errorf("get user: %v", err)
+	}
+	if u.Banned() {
+		return nil, ErrWrongCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(u.Pass), []byte(oldPassword)); err != nil {
+		return nil, ErrWrongCredentials
+	}
+	scopes, err := s.grantScopes(u, nil, requestScope(req))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.UpdatePassword(u.ID, newPassword); err != nil {
+		return nil, fmt.Errorf("update password: %v", err)
+	}
+	if err := s.RevokeAll(u.ID); err != nil {
+		return nil, err
+	}
+	return s.createTokens(u, nil, "", scopes, req)
+}
@@ -0,0 +1,69 @@
+package monban_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/monbantest"
+)
+
+// credentials logs alice in and creates a personal access token for her. It
+// returns the grant of the login and the personal token.
+func credentials(t *testing.T, auth monban.AuthService, u *monban.User) (*monban.Grant, string) {
+	g, err := auth.Login(u.Name, monbantest.Password, nil)
+	if err != nil {
+		t.Fatal("Login failed:", err)
+	}
+	_, personal, err := auth.CreatePersonalToken(u.ID, "ci", []string{"posts:read"}, time.Time{})
+	if err != nil {
+		t.Fatal("CreatePersonalToken failed:", err)
+	}
+	return g, personal
+}
+
+// checkRevoked checks that the tokens of g and the personal token can no
+// longer be used.
+func checkRevoked(t *testing.T, auth monban.AuthService, g *monban.Grant, personal string) {
+	if _, err := auth.Refresh(g.Refresh, nil); err != monban.ErrInvalidToken {
+		t.Errorf("Refresh of old refresh token returned err %v, want %v", err, monban.ErrInvalidToken)
+	}
+	if _, err := auth.Authenticate(g.Access); err != monban.ErrInvalidToken {
+		t.Errorf("Authenticate of old access token returned err %v, want %v", err, monban.ErrInvalidToken)
+	}
+	if _, err := auth.Authenticate(personal); err != monban.ErrInvalidToken {
+		t.Errorf("Authenticate of personal token returned err %v, want %v", err, monban.ErrInvalidToken)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	auth, u, teardown := monbantest.Setup(t, monban.Config{})
+	defer teardown()
+
+	old, personal := credentials(t, auth, u)
+	if _, err := auth.ChangePassword(u.ID, "wrong password", "new password", nil); err != monban.ErrWrongCredentials {
+		t.Errorf("ChangePassword with wrong old password returned err %v, want %v", err, monban.ErrWrongCredentials)
+	}
+	if _, err := auth.ChangePassword(u.ID, monbantest.Password, "short", nil); err != monban.ErrInvalidPassword {
+		t.Errorf("ChangePassword with short new password returned err %v, want %v", err, monban.ErrInvalidPassword)
+	}
+
+	g, err := auth.ChangePassword(u.ID, monbantest.Password, "new password", nil)
+	if err != nil {
+		t.Fatal("ChangePassword failed:", err)
+	}
+	checkRevoked(t, auth, old, personal)
+	// The client that changed the password stays logged in.
+	if _, err := auth.Authenticate(g.Access); err != nil {
+		t.Errorf("Authenticate of new access token returned err: %v", err)
+	}
+	if _, err := auth.Refresh(g.Refresh, nil); err != nil {
+		t.Errorf("Refresh of new refresh token returned err: %v", err)
+	}
+	if _, err := auth.Login(u.Name, monbantest.Password, nil); err != monban.ErrWrongCredentials {
+		t.Errorf("Login with old password returned err %v, want %v", err, monban.ErrWrongCredentials)
+	}
+	if _, err := auth.Login(u.Name, "new password", nil); err != nil {
+		t.Errorf("Login with new password failed: %v", err)
+	}
+}
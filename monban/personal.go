@@ -0,0 +1,182 @@
+package monban
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+// PersonalToken is a long-lived access token that a user creates for scripts
+// instead of sharing their password. Only the hash of the token is stored.
+type PersonalToken struct {
+	ID     string
+	UserID int64
+	Name   string
+	// Hash is the SHA-256 hash of the token given to the user.
+	Hash   string
+	Scopes []string
+	// ExpiresAt is zero for tokens that never expire.
+	ExpiresAt time.Time
+	// LastUsed is zero for tokens that have never been used.
+	LastUsed time.Time
+	Created  time.Time
+}
+
+// PersonalTokenStore describes the storage of the personal access tokens.
+// GetPersonalToken finds a token by its hash. DeletePersonalToken only deletes
+// a token of the given user. They return ErrNotFound for unknown tokens.
+// DeletePersonalTokens deletes all the tokens of a user.
+type PersonalTokenStore interface {
+	CreatePersonalToken(t *PersonalToken) error
+	GetPersonalToken(hash string) (*PersonalToken, error)
+	ListPersonalTokens(userID int64) ([]*PersonalToken, error)
+	DeletePersonalToken(userID int64, id string) error
+	DeletePersonalTokens(userID int64) error
+	UsePersonalToken(id string, lastUsed time.Time) error
+}
+
+const (
+	// personalTokenPrefix tells personal access tokens apart from JWTs and
+	// makes them easy to find when they are leaked.
+	personalTokenPrefix = "mbp_"
+	// personalTokenSize is the size of the personal access tokens in bytes.
+	personalTokenSize = 32
+	// personalTokenUseInterval is how often the last used time of a
+	// personal access token is recorded so that busy scripts do not write
+	// to the database on every request.
+	personalTokenUseInterval = time.Minute
+	// claimPersonalToken marks the tokens of Authenticate that come from a
+	// personal access token. Its value is the name of the personal token.
+	claimPersonalToken = "pat"
+)
+
+// IsPersonalToken reports whether t was authenticated from a personal access
+// token rather than issued as an access token.
+func IsPersonalToken(t *jwt.Token) bool {
+	_, ok := t.Claims[claimPersonalToken]
+	return ok
+}
+
+// CreatePersonalToken creates a personal access token for the user with
+// userID and returns it along with the token itself which is only shown
+// once. The token is granted the given scopes that the class of the user
+// allows or, if none are given, all of them. A zero expiresAt creates a
+// token that never expires.
+func (s *authService) CreatePersonalToken(userID int64, name string, scopes []string, expiresAt time.Time) (*PersonalToken, string, error) {
+	u, err := s.users.GetUserByID(userID)
+	switch err {
+	case ErrNotFound:
+		return nil, "", ErrNotFound
+	case nil:
+	default:
+		return nil, "", fmt.Errorf("get user: %v", err)
+	}
+	if u.Banned() {
+		return nil, "", ErrWrongCredentials
+	}
+	granted, err := s.grantScopes(u, nil, scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	secret, err := randomString(personalTokenSize)
+	if err != nil {
+		return nil, "", fmt.Errorf("personal token creation failed: %v", err)
+	}
+	token := personalTokenPrefix + secret
+	pt := &PersonalToken{
+		ID:        jwt.NewUUID(),
+		UserID:    userID,
+		Name:      name,
+		Hash:      hashCode(token),
+		Scopes:    granted,
+		ExpiresAt: expiresAt,
+		Created:   time.Now(),
+	}
+	if err := s.tokens.CreatePersonalToken(pt); err != nil {
+		return nil, "", fmt.Errorf("create personal token: %v", err)
+	}
+	return pt, token, nil
+}
+
+// PersonalTokens returns the personal access tokens of a user.
+func (s *authService) PersonalTokens(userID int64) ([]*PersonalToken, error) {
+	tokens, err := s.tokens.ListPersonalTokens(userID)
+	if err != nil {
+		return nil, fmt.Errorf("list personal tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+// RevokePersonalToken deletes one of the personal access tokens of a user. It
+// returns ErrNotFound if the user has no such token.
+func (s *authService) RevokePersonalToken(userID int64, id string) error {
+	err := s.tokens.DeletePersonalToken(userID, id)
+	switch err {
+	case ErrNotFound, nil:
+		return err
+	default:
+		return fmt.Errorf("delete personal token: %v", err)
+	}
+}
+
+// isPersonalToken reports whether token looks like a personal access token.
+func isPersonalToken(token string) bool {
+	return strings.HasPrefix(token, personalTokenPrefix)
+}
+
+// authenticatePersonalToken returns an access token equivalent to a personal
+// access token and records that it has been used. The scopes are checked again
+// against the class of the user as it may have changed since the token was
+// created. It returns ErrInvalidToken for unknown or expired tokens and for
+// tokens of users that have been deleted or banned.
+func (s *authService) authenticatePersonalToken(token string) (*jwt.Token, error) {
+	pt, err := s.tokens.GetPersonalToken(hashCode(token))
+	switch err {
+	case ErrNotFound:
+		return nil, ErrInvalidToken
+	case nil:
+	default:
+		return nil, fmt.Errorf("get personal token: %v", err)
+	}
+	now := time.Now()
+	if !pt.ExpiresAt.IsZero() && pt.ExpiresAt.Before(now) {
+		return nil, ErrInvalidToken
+	}
+	u, err := s.users.GetUserByID(pt.UserID)
+	switch err {
+	case ErrNotFound:
+		return nil, ErrInvalidToken
+	case nil:
+	default:
+		return nil, fmt.Errorf("get user: %v", err)
+	}
+	if u.Banned() {
+		return nil, ErrInvalidToken
+	}
+
+	if now.Sub(pt.LastUsed) >= personalTokenUseInterval {
+		if err := s.tokens.UsePersonalToken(pt.ID, now); err != nil {
+			return nil, fmt.Errorf("use personal token: %v", err)
+		}
+	}
+
+	tok := &jwt.Token{
+		Type:     jwt.TypeAccess,
+		ID:       pt.ID,
+		Issuer:   s.issuer,
+		Subject:  userSubject(u),
+		Audience: append([]string{s.issuer}, s.audiences...),
+		IssuedAt: pt.Created.Unix(),
+		Name:     u.Name,
+		Class:    u.Class,
+		Admin:    u.Admin,
+		Scope:    s.filterScopes(u, nil, pt.Scopes),
+		Claims:   map[string]interface{}{claimPersonalToken: pt.Name},
+	}
+	if !pt.ExpiresAt.IsZero() {
+		tok.ExpiresAt = pt.ExpiresAt.Unix()
+	}
+	return tok, nil
+}
@@ -0,0 +1,39 @@
+package monban_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/monbantest"
+)
+
+func TestAuthenticate_personalTokenScopes(t *testing.T) {
+	auth, u, teardown := monbantest.Setup(t, monban.Config{})
+	defer teardown()
+
+	_, personal, err := auth.CreatePersonalToken(u.ID, "ci", []string{"posts:read"}, time.Time{})
+	if err != nil {
+		t.Fatal("CreatePersonalToken failed:", err)
+	}
+	tok, err := auth.Authenticate(personal)
+	if err != nil {
+		t.Fatal("Authenticate failed:", err)
+	}
+	if !tok.HasScope("posts:read") {
+		t.Errorf("personal token has scope %q, want posts:read", tok.Scope)
+	}
+
+	// The scopes of the token are limited by the current class of the user.
+	u.Class = "guest"
+	if err := auth.UpdateUser(u, ""); err != nil {
+		t.Fatal("UpdateUser failed:", err)
+	}
+	tok, err = auth.Authenticate(personal)
+	if err != nil {
+		t.Fatal("Authenticate after class change failed:", err)
+	}
+	if tok.HasScope("posts:read") {
+		t.Errorf("personal token of class without scopes has scope %q", tok.Scope)
+	}
+}
@@ -0,0 +1,139 @@
+package monban
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrResetDisabled is returned when a password reset is requested but there
+// is no Mailer to send the reset tokens with.
+var ErrResetDisabled = errors.New("password reset disabled")
+
+// ResetStore describes the storage of the password reset tokens. UseResetToken
+// must remove the token so that it can be used only once along with all the
+// other tokens of the same user so that none of them outlives the password
+// they were sent for. It returns ErrNotFound for unknown tokens.
+type ResetStore interface {
+	PutResetToken(t *ResetToken) error
+	UseResetToken(hash string) (*ResetToken, error)
+}
+
+// ResetToken is a one-time token that lets a user who forgot their password
+// set a new one.
+type ResetToken struct {
+	// Hash is the SHA-256 hash of the token sent to the user.
+	Hash      string
+	UserID    int64
+	ExpiresAt int64
+}
+
+// Mailer sends emails to the users.
+type Mailer interface {
+	SendMail(to, subject, body string) error
+}
+
+const (
+	// resetTokenDuration is how long password reset tokens can be used for.
+	resetTokenDuration = time.Hour
+	// resetTokenSize is the size of the password reset tokens in bytes.
+	resetTokenSize = 32
+)
+
+// PasswordResetEnabled reports whether there is a Mailer to send password
+// reset tokens with.
+func (s *authService) PasswordResetEnabled() bool {
+	return s.mailer != nil
+}
+
+// RequestPasswordReset emails a password reset token to the user with the
+// given email. Unknown emails and banned users are ignored so that the caller
+// cannot tell whether an account exists. As creating and sending the token
+// takes time for existing accounts only, callers that must not reveal that
+// either should not wait for it, for example by processing the requests on a
+// separate goroutine.
+func (s *authService) RequestPasswordReset(email string) error {
+	if s.mailer == nil {
+		return ErrResetDisabled
+	}
+	// Users migrated from Shimmie may have no email.
+	if email == "" {
+		return nil
+	}
+	u, err := s.users.GetUserByEmail(email)
+	switch err {
+	case ErrNotFound:
+		return nil
+	case nil:
+	default:
+		return fmt.Errorf("get user: %v", err)
+	}
+	if u.Banned() {
+		return nil
+	}
+
+	token, err := randomString(resetTokenSize)
+	if err != nil {
+		return fmt.Errorf("reset token creation failed: %v", err)
+	}
+	t := &ResetToken{
+		Hash:      hashCode(token),
+		UserID:    u.ID,
+		ExpiresAt: time.Now().Add(resetTokenDuration).Unix(),
+	}
+	if err := s.resets.PutResetToken(t); err != nil {
+		return fmt.Errorf("put reset token: %v", err)
+	}
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"Someone asked to reset the password of your account. If it was you,\n"+
+		"use the token below within %v to choose a new password:\n\n"+
+		"%s\n\n"+
+		"If it was not you, you can ignore this email.\n",
+		u.Name, resetTokenDuration, token)
+	if err := s.mailer.SendMail(u.Email, "Password reset", body); err != nil {
+		return fmt.Errorf("send reset mail: %v", err)
+	}
+	return nil
+}
+
+// ResetPassword sets the password of the user the reset token was sent to,
+// ends all of the user's sessions and deletes the user's personal access
+// tokens. It returns ErrInvalidToken if the token is unknown, expired or has
+// already been used. Using a token invalidates the other tokens of the user.
+func (s *authService) ResetPassword(token, newPassword string) error {
+	// The password is checked first so that a rejected password does not
+	// use up the token.
+	if len(newPassword) < minPasswordLength {
+		return ErrInvalidPassword
+	}
+	if token == "" {
+		return ErrInvalidToken
+	}
+	t, err := s.resets.UseResetToken(hashCode(token))
+	switch err {
+	case ErrNotFound:
+		return ErrInvalidToken
+	case nil:
+	default:
+		return fmt.Errorf("use reset token: %v", err)
+	}
+	if t.ExpiresAt < time.Now().Unix() {
+		return ErrInvalidToken
+	}
+	u, err := s.users.GetUserByID(t.UserID)
+	switch err {
+	case ErrNotFound:
+		return ErrInvalidToken
+	case nil:
+	default:
+		return fmt.Errorf("get user: %v", err)
+	}
+	if u.Banned() {
+		return ErrInvalidToken
+	}
+
+	if err := s.users.UpdatePassword(u.ID, newPassword); err != nil {
+		return fmt.Errorf("update password: %v", err)
+	}
+	return s.RevokeAll(u.ID)
+}
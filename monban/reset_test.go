@@ -0,0 +1,82 @@
+package monban_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/monbantest"
+)
+
+// resetToken returns the token of a password reset email.
+func resetToken(t *testing.T, m monbantest.Mail) string {
+	for _, line := range strings.Split(m.Body, "\n") {
+		if line != "" && !strings.Contains(line, " ") {
+			return line
+		}
+	}
+	t.Fatalf("no token in reset email: %q", m.Body)
+	return ""
+}
+
+func TestResetPassword(t *testing.T) {
+	mailer := new(monbantest.Mailer)
+	auth, u, teardown := monbantest.Setup(t, monban.Config{Mailer: mailer})
+	defer teardown()
+
+	// Unknown emails are ignored silently.
+	if err := auth.RequestPasswordReset("bob@example.com"); err != nil {
+		t.Fatal("RequestPasswordReset of unknown email failed:", err)
+	}
+	if n := len(mailer.Sent()); n != 0 {
+		t.Fatalf("RequestPasswordReset of unknown email sent %d emails, want 0", n)
+	}
+	for i := 0; i < 2; i++ {
+		if err := auth.RequestPasswordReset(u.Email); err != nil {
+			t.Fatal("RequestPasswordReset failed:", err)
+		}
+	}
+	sent := mailer.Sent()
+	if len(sent) != 2 || sent[0].To != u.Email {
+		t.Fatalf("RequestPasswordReset sent %v, want 2 emails to %q", sent, u.Email)
+	}
+	token, other := resetToken(t, sent[0]), resetToken(t, sent[1])
+
+	old, personal := credentials(t, auth, u)
+	// A rejected password does not use up the token.
+	if err := auth.ResetPassword(token, "short"); err != monban.ErrInvalidPassword {
+		t.Errorf("ResetPassword with short password returned err %v, want %v", err, monban.ErrInvalidPassword)
+	}
+	if err := auth.ResetPassword(token, "new password"); err != nil {
+		t.Fatal("ResetPassword failed:", err)
+	}
+	checkRevoked(t, auth, old, personal)
+	if _, err := auth.Login(u.Name, "new password", nil); err != nil {
+		t.Errorf("Login with new password failed: %v", err)
+	}
+
+	// Tokens are used once and take the other tokens of the user with them.
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"used", token},
+		{"other", other},
+		{"unknown", "unknown"},
+		{"empty", ""},
+	}
+	for _, tt := range tests {
+		if err := auth.ResetPassword(tt.token, "another password"); err != monban.ErrInvalidToken {
+			t.Errorf("%s: ResetPassword returned err %v, want %v", tt.name, err, monban.ErrInvalidToken)
+		}
+	}
+}
+
+func TestResetPassword_disabled(t *testing.T) {
+	auth, u, teardown := monbantest.Setup(t, monban.Config{})
+	defer teardown()
+
+	if err := auth.RequestPasswordReset(u.Email); err != monban.ErrResetDisabled {
+		t.Errorf("RequestPasswordReset without mailer returned err %v, want %v", err, monban.ErrResetDisabled)
+	}
+}
@@ -0,0 +1,107 @@
+package monban
+
+import (
+	"fmt"
+	"strings"
+)
+
+// identityScopes are the OpenID Connect scopes. They do not grant access to
+// anything but the identity of the user so every user and client can be
+// granted them.
+var identityScopes = []string{scopeOpenID, "profile", "email"}
+
+// ScopePolicy maps user classes to the scopes their users can be granted. The
+// scopes of class "*" apply to the classes that are not listed. Users of
+// other classes can only be granted the OpenID Connect scopes.
+type ScopePolicy map[string][]string
+
+// ParseScopePolicy parses a scope policy in the format
+// "class=scope scope;class=scope", for example
+// "user=posts:read posts:write;admin=posts:read posts:write users:admin".
+func ParseScopePolicy(s string) (ScopePolicy, error) {
+	p := make(ScopePolicy)
+	for _, entry := range strings.Split(s, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		kv := strings.SplitN(entry, "=", 2)
+		class := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || class == "" {
+			return nil, fmt.Errorf("invalid scope policy entry %q", entry)
+		}
+		p[class] = strings.Fields(kv[1])
+	}
+	return p, nil
+}
+
+// classScopes returns the scopes that users of class can be granted.
+func (p ScopePolicy) classScopes(class string) []string {
+	if scopes, ok := p[class]; ok {
+		return scopes
+	}
+	return p["*"]
+}
+
+// allowedScope reports whether user u can be granted scope through client c.
+// Without a client, only the class of the user is taken into account.
+func (s *authService) allowedScope(u *User, c *Client, scope string) bool {
+	if contains(identityScopes, scope) {
+		return true
+	}
+	if u.Banned() || !contains(s.scopes.classScopes(u.Class), scope) {
+		return false
+	}
+	return c == nil || contains(c.Scopes, scope)
+}
+
+// grantScopes returns the scopes that user u is granted through client c when
+// requesting the given scopes. If none are requested, all the scopes allowed
+// by the class of the user and the client are granted. Scopes that are not
+// allowed are left out but the request fails with ErrInvalidScope if none of
+// the requested scopes is allowed.
+func (s *authService) grantScopes(u *User, c *Client, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return s.filterScopes(u, c, s.scopes.classScopes(u.Class)), nil
+	}
+	granted := s.filterScopes(u, c, requested)
+	if len(granted) == 0 {
+		return nil, ErrInvalidScope
+	}
+	return granted, nil
+}
+
+// narrows reports whether the requested scopes are a subset of the parent
+// scopes of a refresh token. A refreshed token can never have a wider scope
+// than its refresh token.
+func narrows(requested, parent []string) bool {
+	for _, sc := range requested {
+		if !contains(parent, sc) {
+			return false
+		}
+	}
+	return true
+}
+
+// refreshScopes returns the scopes that user u is granted through client c
+// when refreshing a token with the parent scopes. The requested scopes must
+// narrow the parent scopes which are granted again if none are requested.
+// Scopes that are no longer allowed, for example because the class of the
+// user changed, are dropped.
+func (s *authService) refreshScopes(u *User, c *Client, requested, parent []string) []string {
+	if len(requested) == 0 {
+		requested = parent
+	}
+	return s.filterScopes(u, c, requested)
+}
+
+// filterScopes returns the scopes that user u is allowed to be granted through
+// client c without duplicates.
+func (s *authService) filterScopes(u *User, c *Client, scopes []string) []string {
+	var allowed []string
+	for _, sc := range scopes {
+		if s.allowedScope(u, c, sc) && !contains(allowed, sc) {
+			allowed = append(allowed, sc)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,46 @@
+package monban
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGrantScopes(t *testing.T) {
+	s := &authService{scopes: ScopePolicy{
+		"user":  {"posts:read", "posts:write"},
+		"admin": {"posts:read", "posts:write", "users:admin"},
+	}}
+	user := &User{Class: "user"}
+	admin := &User{Class: "admin"}
+	banned := &User{Class: classBanned}
+	wiki := &Client{Scopes: []string{"posts:read", "users:admin"}}
+	noScopes := &Client{}
+
+	tests := []struct {
+		u         *User
+		c         *Client
+		requested []string
+		want      []string
+		err       error
+	}{
+		{user, nil, nil, []string{"posts:read", "posts:write"}, nil},
+		{user, wiki, nil, []string{"posts:read"}, nil},
+		{admin, wiki, nil, []string{"posts:read", "users:admin"}, nil},
+		{admin, wiki, []string{"posts:write", "users:admin"}, []string{"users:admin"}, nil},
+		{user, wiki, []string{"openid", "posts:read", "posts:read"}, []string{"openid", "posts:read"}, nil},
+		{user, wiki, []string{"posts:write"}, nil, ErrInvalidScope},
+		{user, noScopes, nil, nil, nil},
+		{user, noScopes, []string{"openid", "posts:read"}, []string{"openid"}, nil},
+		{banned, wiki, []string{"posts:read"}, nil, ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		got, err := s.grantScopes(tt.u, tt.c, tt.requested)
+		if err != tt.err {
+			t.Errorf("grantScopes(%q, %v, %q) returned err %v, want %v", tt.u.Class, tt.c, tt.requested, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("grantScopes(%q, %v, %q) = %q, want %q", tt.u.Class, tt.c, tt.requested, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package monban
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+)
+
+// Session describes a login of a user on a device. All the refresh tokens
+// that are rotated from a single login belong to the same session which is
+// identified by their token family.
+type Session struct {
+	ID          string
+	Subject     string
+	Created     time.Time
+	LastRefresh time.Time
+	IP          string
+	UserAgent   string
+}
+
+// nextSession returns the session of the family of refresh token t updated
+// to record that t has been issued to the client described by req. A new
+// session is created for the first token of a family.
+func (s *authService) nextSession(t *jwt.Token, req *TokenRequest) (*Session, error) {
+	now := time.Unix(t.IssuedAt, 0)
+	sess, err := s.whitelist.GetSession(t.Family)
+	switch err {
+	case ErrNotFound:
+		sess = &Session{
+			ID:      t.Family,
+			Subject: t.Subject,
+			Created: now,
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("get session: %v", err)
+	}
+	sess.LastRefresh = now
+	if req != nil {
+		sess.IP = req.IP
+		sess.UserAgent = req.UserAgent
+	}
+	return sess, nil
+}
+
+// Sessions returns the active sessions of a user.
+func (s *authService) Sessions(userID int64) ([]*Session, error) {
+	sessions, err := s.whitelist.ListUserTokens(strconv.FormatInt(userID, 10))
+	if err != nil {
+		return nil, fmt.Errorf("list user tokens: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one of the sessions of a user. It returns ErrNotFound if
+// the user has no such session.
+func (s *authService) RevokeSession(userID int64, sessionID string) error {
+	sess, err := s.whitelist.GetSession(sessionID)
+	if err != nil {
+		return err
+	}
+	if sess.Subject != strconv.FormatInt(userID, 10) {
+		return ErrNotFound
+	}
+	if err := s.whitelist.DeleteFamily(sessionID); err != nil {
+		return fmt.Errorf("delete token family: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package monban
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/kusubooru/shimmie"
+)
+
+// ErrUserExists is returned when creating or renaming a user to a name that is
+// already taken in Monban or in Shimmie, where users are migrated from.
+var ErrUserExists = errors.New("user already exists")
+
+// UserFilter selects the users that are listed. Empty fields match all the
+// users.
+type UserFilter struct {
+	Class string
+	// Admin, if not nil, matches the users whose admin status is *Admin.
+	Admin      *bool
+	NamePrefix string
+	// Offset and Limit select a page of the users ordered by ID. A zero
+	// Limit means no limit.
+	Offset int
+	Limit  int
+}
+
+const (
+	// defaultUserLimit is the page size of ListUsers when none is given.
+	defaultUserLimit = 50
+	// maxUserLimit is the largest page size of ListUsers.
+	maxUserLimit = 500
+)
+
+// ListUsers returns a page of the users that match filter f. Pages are
+// limited to maxUserLimit users.
+func (s *authService) ListUsers(f *UserFilter) ([]*User, error) {
+	page := UserFilter{}
+	if f != nil {
+		page = *f
+	}
+	if page.Offset < 0 {
+		page.Offset = 0
+	}
+	if page.Limit <= 0 {
+		page.Limit = defaultUserLimit
+	}
+	if page.Limit > maxUserLimit {
+		page.Limit = maxUserLimit
+	}
+	users, err := s.users.ListUsers(&page)
+	if err != nil {
+		return nil, fmt.Errorf("list users: %v", err)
+	}
+	return users, nil
+}
+
+// UserByID returns the user with id. It returns ErrNotFound if there is no
+// such user.
+func (s *authService) UserByID(id int64) (*User, error) {
+	return s.getUser(s.users.GetUserByID(id))
+}
+
+// UserByEmail returns the user with email. It returns ErrNotFound if there is
+// no such user.
+func (s *authService) UserByEmail(email string) (*User, error) {
+	if email == "" {
+		return nil, ErrNotFound
+	}
+	return s.getUser(s.users.GetUserByEmail(email))
+}
+
+func (s *authService) getUser(u *User, err error) (*User, error) {
+	switch err {
+	case ErrNotFound:
+		return nil, ErrNotFound
+	case nil:
+		return u, nil
+	default:
+		return nil, fmt.Errorf("get user: %v", err)
+	}
+}
+
+// CreateUser creates a user with the password u.Pass. It returns
+// ErrUserExists if the name is taken and ErrInvalidPassword if the password is
+// too short.
+func (s *authService) CreateUser(u *User) error {
+	if len(u.Pass) < minPasswordLength {
+		return ErrInvalidPassword
+	}
+	if err := s.checkNameFree(u.Name); err != nil {
+		return err
+	}
+	if err := s.users.CreateUser(u); err != nil {
+		return fmt.Errorf("create user: %v", err)
+	}
+	created, err := s.users.GetUser(u.Name)
+	if err != nil {
+		return fmt.Errorf("get created user: %v", err)
+	}
+	*u = *created
+	return nil
+}
+
+// UpdateUser changes the name, email, class and admin status of user u and,
+// if password is not empty, the password. The sessions of the user are ended
+// if any of the claims of the tokens change so that the user is not left with
+// tokens that grant more than they should. A new password also deletes the
+// personal access tokens of the user like a password change. It returns
+// ErrInvalidPassword if the password is too short.
+func (s *authService) UpdateUser(u *User, password string) error {
+	if password != "" && len(password) < minPasswordLength {
+		return ErrInvalidPassword
+	}
+	old, err := s.UserByID(u.ID)
+	if err != nil {
+		return err
+	}
+	if u.Name != old.Name {
+		if err := s.checkNameFree(u.Name); err != nil {
+			return err
+		}
+	}
+	if err := s.users.UpdateUser(u); err != nil {
+		return fmt.Errorf("update user: %v", err)
+	}
+	if password != "" {
+		if err := s.users.UpdatePassword(u.ID, password); err != nil {
+			return fmt.Errorf("update password: %v", err)
+		}
+		return s.RevokeAll(u.ID)
+	}
+	if u.Name != old.Name || u.Class != old.Class || u.Admin != old.Admin {
+		return s.endSessions(u.ID)
+	}
+	return nil
+}
+
+// DeleteUser deletes the user with id, ends all of the user's sessions and
+// deletes the user's personal access tokens. It returns ErrNotFound if there
+// is no such user.
+func (s *authService) DeleteUser(id int64) error {
+	err := s.users.DeleteUser(id)
+	switch err {
+	case ErrNotFound:
+		return ErrNotFound
+	case nil:
+	default:
+		return fmt.Errorf("delete user: %v", err)
+	}
+	return s.RevokeAll(id)
+}
+
+// checkNameFree returns ErrUserExists if name is taken by a Monban user or by
+// a Shimmie user that has not been migrated yet.
+func (s *authService) checkNameFree(name string) error {
+	_, err := s.users.GetUser(name)
+	switch err {
+	case nil:
+		return ErrUserExists
+	case ErrNotFound:
+	default:
+		return fmt.Errorf("get user: %v", err)
+	}
+	_, err = s.shimmie.GetUserByName(name)
+	switch err {
+	case nil:
+		return ErrUserExists
+	case shimmie.ErrNotFound:
+		return nil
+	default:
+		return fmt.Errorf("get shimmie user: %v", err)
+	}
+}
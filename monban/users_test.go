@@ -0,0 +1,101 @@
+package monban_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/monbantest"
+)
+
+func TestUpdateUser(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(u *monban.User)
+		password string
+		// session and personal report whether the session and the personal
+		// access token of the user survive the update.
+		session  bool
+		personal bool
+	}{
+		{"email", func(u *monban.User) { u.Email = "alice@example.org" }, "", true, true},
+		{"class", func(u *monban.User) { u.Class = "admin" }, "", false, true},
+		{"admin", func(u *monban.User) { u.Admin = true }, "", false, true},
+		{"name", func(u *monban.User) { u.Name = "alicia" }, "", false, true},
+		{"password", func(u *monban.User) {}, "new password", false, false},
+	}
+	for _, tt := range tests {
+		auth, u, teardown := monbantest.Setup(t, monban.Config{})
+		g, err := auth.Login("alice", monbantest.Password, nil)
+		if err != nil {
+			t.Fatalf("%s: Login failed: %v", tt.name, err)
+		}
+		_, personal, err := auth.CreatePersonalToken(u.ID, "ci", []string{"posts:read"}, time.Time{})
+		if err != nil {
+			t.Fatalf("%s: CreatePersonalToken failed: %v", tt.name, err)
+		}
+
+		tt.update(u)
+		if err := auth.UpdateUser(u, tt.password); err != nil {
+			t.Fatalf("%s: UpdateUser failed: %v", tt.name, err)
+		}
+		if _, err := auth.Refresh(g.Refresh, nil); (err == nil) != tt.session {
+			t.Errorf("%s: Refresh after update returned err %v, want session kept %t", tt.name, err, tt.session)
+		}
+		if _, err := auth.Authenticate(personal); (err == nil) != tt.personal {
+			t.Errorf("%s: Authenticate of personal token after update returned err %v, want token kept %t", tt.name, err, tt.personal)
+		}
+		if tt.password != "" {
+			if _, err := auth.Login(u.Name, tt.password, nil); err != nil {
+				t.Errorf("%s: Login with new password failed: %v", tt.name, err)
+			}
+		}
+		teardown()
+	}
+}
+
+func TestUpdateUser_invalid(t *testing.T) {
+	auth, u, teardown := monbantest.Setup(t, monban.Config{})
+	defer teardown()
+
+	if err := auth.UpdateUser(u, "short"); err != monban.ErrInvalidPassword {
+		t.Errorf("UpdateUser with short password returned err %v, want %v", err, monban.ErrInvalidPassword)
+	}
+	bob := &monban.User{Name: "bob", Pass: monbantest.Password}
+	if err := auth.CreateUser(bob); err != nil {
+		t.Fatal("CreateUser failed:", err)
+	}
+	u.Name = "bob"
+	if err := auth.UpdateUser(u, ""); err != monban.ErrUserExists {
+		t.Errorf("UpdateUser to taken name returned err %v, want %v", err, monban.ErrUserExists)
+	}
+	missing := &monban.User{ID: 1000, Name: "carol"}
+	if err := auth.UpdateUser(missing, ""); err != monban.ErrNotFound {
+		t.Errorf("UpdateUser of missing user returned err %v, want %v", err, monban.ErrNotFound)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	auth, u, teardown := monbantest.Setup(t, monban.Config{})
+	defer teardown()
+
+	g, err := auth.Login("alice", monbantest.Password, nil)
+	if err != nil {
+		t.Fatal("Login failed:", err)
+	}
+	if err := auth.DeleteUser(u.ID); err != nil {
+		t.Fatal("DeleteUser failed:", err)
+	}
+	if _, err := auth.Refresh(g.Refresh, nil); err != monban.ErrInvalidToken {
+		t.Errorf("Refresh after DeleteUser returned err %v, want %v", err, monban.ErrInvalidToken)
+	}
+	if _, err := auth.Authenticate(g.Access); err != monban.ErrInvalidToken {
+		t.Errorf("Authenticate after DeleteUser returned err %v, want %v", err, monban.ErrInvalidToken)
+	}
+	if _, err := auth.UserByID(u.ID); err != monban.ErrNotFound {
+		t.Errorf("UserByID after DeleteUser returned err %v, want %v", err, monban.ErrNotFound)
+	}
+	if err := auth.DeleteUser(u.ID); err != monban.ErrNotFound {
+		t.Errorf("DeleteUser of deleted user returned err %v, want %v", err, monban.ErrNotFound)
+	}
+}
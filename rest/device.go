@@ -0,0 +1,184 @@
+package rest
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kusubooru/monban/monban"
+)
+
+// Device authorization grant error codes as defined by RFC 8628.
+const (
+	authorizationPendingType = "authorization_pending"
+	slowDownType             = "slow_down"
+	expiredTokenType         = "expired_token"
+)
+
+// deviceCodeGrantType is the grant type devices poll the token endpoint with.
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+var deviceTmpl = template.Must(template.New("device").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Connect a device{{with .Client}} to {{.Name}}{{end}}</title>
+</head>
+<body>
+{{if .Done}}
+<h1>{{.Done}}</h1>
+<p>You can return to your device.</p>
+{{else}}
+<h1>{{with .Client}}Log in to allow {{.Name}}{{else}}Connect a device{{end}}</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post" action="/device">
+	<p><label>Code <input type="text" name="user_code" value="{{.UserCode}}" autocomplete="off"{{if not .UserCode}} autofocus{{end}}></label></p>
+	<p><label>Username <input type="text" name="username" value="{{.Username}}"{{if .UserCode}} autofocus{{end}}></label></p>
+	<p><label>Password <input type="password" name="password"></label></p>
+	<p>{{with .Client}}{{.Name}}{{else}}The device{{end}} will be able to act on your behalf.</p>
+	<button type="submit" name="action" value="allow">Allow</button>
+	<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{end}}
+</body>
+</html>
+`))
+
+type devicePage struct {
+	Client   *monban.Client
+	UserCode string
+	Username string
+	Error    string
+	Done     string
+}
+
+// renderDevice renders the verification page of the device authorization
+// grant.
+func renderDevice(w http.ResponseWriter, code int, page *devicePage) error {
+	setLoginPageHeaders(w)
+	w.WriteHeader(code)
+	if err := deviceTmpl.Execute(w, page); err != nil {
+		return E(err, "device page render failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// deviceError returns the token endpoint error of a device that polls before
+// it has been granted tokens or nil if err is not such an error.
+func deviceError(err error) error {
+	switch err {
+	case monban.ErrAuthorizationPending:
+		return &Error{err: err, Message: "authorization pending", Code: http.StatusBadRequest, Type: authorizationPendingType}
+	case monban.ErrSlowDown:
+		return &Error{err: err, Message: "polling too often", Code: http.StatusBadRequest, Type: slowDownType}
+	case monban.ErrExpiredToken:
+		return &Error{err: err, Message: "device code expired", Code: http.StatusBadRequest, Type: expiredTokenType}
+	case monban.ErrAccessDenied:
+		return &Error{err: err, Message: "access denied", Code: http.StatusBadRequest, Type: accessDeniedType}
+	}
+	return nil
+}
+
+type deviceCodeResp struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// handleDeviceCode implements the device authorization endpoint of RFC 8628.
+// Devices that cannot show a login page ask for a user code that the user
+// enters on the verification page while the device polls the token endpoint.
+func (s *server) handleDeviceCode(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	c, err := s.authenticateClient(w, r)
+	if err != nil {
+		return err
+	}
+	da, err := s.auth.RequestDeviceCode(c, r.PostFormValue("scope"))
+	if err != nil {
+		return E(err, "device authorization failed", http.StatusInternalServerError)
+	}
+	verificationURI := strings.TrimSuffix(s.issuer, "/") + "/device"
+	resp := &deviceCodeResp{
+		DeviceCode:              da.DeviceCode,
+		UserCode:                da.UserCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?" + url.Values{"user_code": {da.UserCode}}.Encode(),
+		ExpiresIn:               int64(da.ExpiresIn.Seconds()),
+		Interval:                int64(da.Interval.Seconds()),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "device authorization response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// handleDevice implements the verification page of the device authorization
+// grant. GET shows a page where the user enters the user code, logs in and
+// allows or denies the device. POST handles the submitted page. Both allowing
+// and denying need the credentials of the user.
+func (s *server) handleDevice(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" && r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	if err := r.ParseForm(); err != nil {
+		return E(err, "invalid device request", http.StatusBadRequest)
+	}
+	userCode := r.Form.Get("user_code")
+	page := &devicePage{UserCode: userCode}
+	if r.Method == "GET" && userCode == "" {
+		return renderDevice(w, http.StatusOK, page)
+	}
+
+	c, err := s.auth.CheckUserCode(userCode)
+	switch err {
+	case monban.ErrInvalidGrant:
+		page.Error = "Unknown or expired code."
+		return renderDevice(w, http.StatusBadRequest, page)
+	case nil:
+	default:
+		return E(err, "device authorization failed", http.StatusInternalServerError)
+	}
+	page.Client = c
+	if r.Method == "GET" {
+		return renderDevice(w, http.StatusOK, page)
+	}
+
+	username, password := r.PostForm.Get("username"), r.PostForm.Get("password")
+	allow := r.PostForm.Get("action") == "allow"
+	if allow {
+		err = s.auth.ApproveDevice(username, password, userCode)
+	} else {
+		err = s.auth.DenyDevice(username, password, userCode)
+	}
+	switch err {
+	case monban.ErrWrongCredentials:
+		page.Username = username
+		page.Error = "Wrong username or password."
+		return renderDevice(w, http.StatusUnauthorized, page)
+	case monban.ErrInvalidScope:
+		page.Error = "The device asks for access that you are not allowed to give."
+		return renderDevice(w, http.StatusForbidden, page)
+	case monban.ErrInvalidGrant:
+		page.Client = nil
+		page.Error = "Unknown or expired code."
+		return renderDevice(w, http.StatusBadRequest, page)
+	case nil:
+	default:
+		return E(err, "device authorization failed", http.StatusInternalServerError)
+	}
+	if !allow {
+		return renderDevice(w, http.StatusOK, &devicePage{Done: "Device denied"})
+	}
+	return renderDevice(w, http.StatusOK, &devicePage{Done: "Device connected"})
+}
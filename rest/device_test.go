@@ -0,0 +1,176 @@
+package rest_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kusubooru/monban/jwt"
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/boltdb"
+	"github.com/kusubooru/monban/monban/monbantest"
+	"github.com/kusubooru/monban/rest"
+)
+
+// deviceTest drives the device authorization grant of a server through HTTP
+// for a confidential client. The device store is kept so that the tests can
+// look at the device codes and expire them.
+type deviceTest struct {
+	t       *testing.T
+	srv     rest.Server
+	devices monban.DeviceStore
+	client  *monban.Client
+	secret  string
+}
+
+func newDeviceTest(t *testing.T) (*deviceTest, func()) {
+	f, err := ioutil.TempFile("", "monban_rest_device_tmpfile_")
+	if err != nil {
+		t.Fatal("could not create boltdb temp file for tests:", err)
+	}
+	db := boltdb.Open(f.Name())
+	keys := jwt.NewHMACKeySet([]byte("secret"))
+	auth, _, teardown := monbantest.Setup(t, monban.Config{Devices: db.DeviceStore(), Keys: keys})
+	srv := rest.NewServer(auth, keys, "monban")
+	cleanup := func() {
+		srv.Close()
+		teardown()
+		db.Close()
+		os.Remove(f.Name())
+	}
+
+	c := &monban.Client{Name: "tv", Scopes: []string{"posts:read"}}
+	secret, err := auth.RegisterClient(c)
+	if err != nil {
+		cleanup()
+		t.Fatal("RegisterClient failed:", err)
+	}
+	return &deviceTest{t: t, srv: srv, devices: db.DeviceStore(), client: c, secret: secret}, cleanup
+}
+
+// post sends a form to the server, with the client credentials if client is
+// set, and returns the response.
+func (dt *deviceTest) post(path string, form url.Values, client bool) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if client {
+		r.SetBasicAuth(dt.client.ID, dt.secret)
+	}
+	w := httptest.NewRecorder()
+	dt.srv.ServeHTTP(w, r)
+	return w
+}
+
+// request starts a device authorization request and returns its device and
+// user codes.
+func (dt *deviceTest) request() (string, string) {
+	w := dt.post("/device/code", url.Values{"scope": {"posts:read"}}, true)
+	if got, want := w.Code, http.StatusOK; got != want {
+		dt.t.Fatalf("POST /device/code status = %d, want %d (body: %s)", got, want, w.Body)
+	}
+	var resp struct {
+		DeviceCode string `json:"device_code"`
+		UserCode   string `json:"user_code"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		dt.t.Fatal("decoding POST /device/code response failed:", err)
+	}
+	return resp.DeviceCode, resp.UserCode
+}
+
+// poll polls the token endpoint with deviceCode and checks that it fails with
+// the error errType or succeeds if errType is empty.
+func (dt *deviceTest) poll(name, deviceCode, errType string) {
+	w := dt.post("/token", url.Values{"grant_type": {"urn:ietf:params:oauth:grant-type:device_code"}, "device_code": {deviceCode}}, true)
+	if errType == "" {
+		if got, want := w.Code, http.StatusOK; got != want {
+			dt.t.Errorf("%s: poll status = %d, want %d (body: %s)", name, got, want, w.Body)
+		}
+		if !strings.Contains(w.Body.String(), "access_token") {
+			dt.t.Errorf("%s: poll body = %s, want access token", name, w.Body)
+		}
+		return
+	}
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		dt.t.Errorf("%s: poll status = %d, want %d (body: %s)", name, got, want, w.Body)
+	}
+	if !strings.Contains(w.Body.String(), `"error":"`+errType+`"`) {
+		dt.t.Errorf("%s: poll body = %s, want error %s", name, w.Body, errType)
+	}
+}
+
+// decide submits the verification page with action for userCode and checks
+// its status.
+func (dt *deviceTest) decide(name, userCode, action, password string, want int) {
+	form := url.Values{"user_code": {userCode}, "action": {action}, "username": {"alice"}, "password": {password}}
+	w := dt.post("/device", form, false)
+	if got := w.Code; got != want {
+		dt.t.Errorf("%s: POST /device status = %d, want %d (body: %s)", name, got, want, w.Body)
+	}
+}
+
+// deviceCode returns the stored device code with userCode.
+func (dt *deviceTest) deviceCode(userCode string) *monban.DeviceCode {
+	d, err := dt.devices.GetDeviceCode(strings.Replace(userCode, "-", "", -1))
+	if err != nil {
+		dt.t.Fatal("GetDeviceCode failed:", err)
+	}
+	return d
+}
+
+func TestDevice_approve(t *testing.T) {
+	dt, cleanup := newDeviceTest(t)
+	defer cleanup()
+
+	deviceCode, userCode := dt.request()
+	dt.poll("pending", deviceCode, "authorization_pending")
+
+	// Polling before the interval has passed slows the device down for
+	// good.
+	interval := dt.deviceCode(userCode).Interval
+	dt.poll("too often", deviceCode, "slow_down")
+	if got, want := dt.deviceCode(userCode).Interval, interval+5; got != want {
+		t.Errorf("interval after slow_down = %d, want %d", got, want)
+	}
+
+	dt.decide("wrong password", userCode, "allow", "wrong", http.StatusUnauthorized)
+	dt.decide("allow", userCode, "allow", monbantest.Password, http.StatusOK)
+	dt.poll("approved", deviceCode, "")
+	// Tokens are issued only once.
+	dt.poll("used", deviceCode, "invalid_grant")
+}
+
+func TestDevice_deny(t *testing.T) {
+	dt, cleanup := newDeviceTest(t)
+	defer cleanup()
+
+	deviceCode, userCode := dt.request()
+	// Anyone who sees the user code must not be able to deny the device.
+	dt.decide("deny without password", userCode, "deny", "", http.StatusUnauthorized)
+	if got, want := dt.deviceCode(userCode).Status, monban.DevicePending; got != want {
+		t.Errorf("status after deny without password = %v, want %v", got, want)
+	}
+	dt.decide("deny", userCode, "deny", monbantest.Password, http.StatusOK)
+	dt.poll("denied", deviceCode, "access_denied")
+	dt.decide("allow after deny", userCode, "allow", monbantest.Password, http.StatusBadRequest)
+}
+
+func TestDevice_expired(t *testing.T) {
+	dt, cleanup := newDeviceTest(t)
+	defer cleanup()
+
+	deviceCode, userCode := dt.request()
+	d := dt.deviceCode(userCode)
+	d.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	if err := dt.devices.UpdateDeviceCode(d); err != nil {
+		t.Fatal("UpdateDeviceCode failed:", err)
+	}
+	dt.decide("allow expired", userCode, "allow", monbantest.Password, http.StatusBadRequest)
+	dt.poll("expired", deviceCode, "expired_token")
+}
@@ -3,6 +3,9 @@ package rest
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/kusubooru/monban/jwt"
+	"github.com/kusubooru/monban/monban"
 )
 
 type internal interface {
@@ -20,6 +23,9 @@ type Error struct {
 	err     error  `json:"-"`
 	Message string `json:"message"`
 	Code    int    `json:"code"`
+	// Type is a machine readable code that describes the error, for example
+	// "token_expired" which means that the client should refresh its tokens.
+	Type string `json:"error,omitempty"`
 }
 
 // Internal implements the Internal interface and allows to inspect if the
@@ -31,3 +37,27 @@ func (e *Error) Error() string  { return fmt.Sprintf("%d %v: %v", e.Code, e.Mess
 func E(err error, message string, code int) error {
 	return &Error{err: err, Message: message, Code: code}
 }
+
+const (
+	// invalidTokenType is the type of the errors about tokens that are well
+	// formed but not accepted, for example because they have been revoked.
+	invalidTokenType = "invalid_token"
+	// invalidClientType is the type of the errors about clients that failed
+	// to authenticate.
+	invalidClientType = "invalid_client"
+	// invalidRequestType is the type of the errors about requests that miss
+	// a required parameter.
+	invalidRequestType = "invalid_request"
+)
+
+// tokenError returns an Error that describes why a token was rejected or nil
+// if err is not about a rejected token.
+func tokenError(err error) error {
+	if e, ok := err.(*jwt.Error); ok {
+		return &Error{err: err, Message: "invalid token", Code: http.StatusUnauthorized, Type: e.Kind.String()}
+	}
+	if err == monban.ErrInvalidToken {
+		return &Error{err: err, Message: "invalid token", Code: http.StatusUnauthorized, Type: invalidTokenType}
+	}
+	return nil
+}
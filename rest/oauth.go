@@ -0,0 +1,246 @@
+package rest
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/kusubooru/monban/jwt"
+	"github.com/kusubooru/monban/monban"
+)
+
+// OAuth 2.0 error codes as defined by RFC 6749.
+const (
+	invalidGrantType            = "invalid_grant"
+	unsupportedGrantType        = "unsupported_grant_type"
+	unsupportedResponseTypeType = "unsupported_response_type"
+	accessDeniedType            = "access_denied"
+	unauthorizedClientType      = "unauthorized_client"
+	invalidScopeType            = "invalid_scope"
+)
+
+var authorizeTmpl = template.Must(template.New("authorize").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Authorize{{with .Client}} {{.Name}}{{end}}</title>
+</head>
+<body>
+{{if .Client}}
+<h1>Log in to authorize {{.Client.Name}}</h1>
+{{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+<form method="post" action="/authorize">
+	<input type="hidden" name="response_type" value="code">
+	<input type="hidden" name="client_id" value="{{.Client.ID}}">
+	<input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+	<input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+	<input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+	<input type="hidden" name="scope" value="{{.Request.Scope}}">
+	<input type="hidden" name="nonce" value="{{.Request.Nonce}}">
+	<input type="hidden" name="state" value="{{.State}}">
+	<p><label>Username <input type="text" name="username" value="{{.Username}}" autofocus></label></p>
+	<p><label>Password <input type="password" name="password"></label></p>
+	<p>{{.Client.Name}} will be able to act on your behalf.</p>
+	<button type="submit" name="action" value="allow">Allow</button>
+	<button type="submit" name="action" value="deny">Deny</button>
+</form>
+{{else}}
+<h1>Authorization failed</h1>
+<p>{{.Error}}</p>
+{{end}}
+</body>
+</html>
+`))
+
+type authorizePage struct {
+	Client   *monban.Client
+	Request  *monban.AuthorizeRequest
+	State    string
+	Username string
+	Error    string
+}
+
+// setLoginPageHeaders sets the headers of the HTML pages that ask for the
+// password of the user. They may not be framed, to prevent clickjacking, nor
+// cached.
+func setLoginPageHeaders(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// renderAuthorize renders the authorize page.
+func renderAuthorize(w http.ResponseWriter, code int, page *authorizePage) error {
+	setLoginPageHeaders(w)
+	w.WriteHeader(code)
+	if err := authorizeTmpl.Execute(w, page); err != nil {
+		return E(err, "authorize page render failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// redirectError sends the user back to the client with an OAuth error.
+func redirectError(w http.ResponseWriter, r *http.Request, redirectURI, state, errType string) error {
+	return redirect(w, r, redirectURI, url.Values{"error": {errType}}, state)
+}
+
+func redirect(w http.ResponseWriter, r *http.Request, redirectURI string, params url.Values, state string) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return E(err, "invalid redirect URI", http.StatusBadRequest)
+	}
+	q := u.Query()
+	for k, v := range params {
+		q[k] = v
+	}
+	if state != "" {
+		q.Set("state", state)
+	}
+	u.RawQuery = q.Encode()
+	http.Redirect(w, r, u.String(), http.StatusFound)
+	return nil
+}
+
+// handleAuthorize implements the authorization endpoint of the OAuth 2.0
+// authorization code flow with PKCE. GET shows a page where the user logs in
+// and allows or denies the client. POST handles the submitted page and sends
+// the user back to the client with an authorization code.
+func (s *server) handleAuthorize(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" && r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	if err := r.ParseForm(); err != nil {
+		return E(err, "invalid authorization request", http.StatusBadRequest)
+	}
+	req := &monban.AuthorizeRequest{
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		Scope:               r.Form.Get("scope"),
+		Nonce:               r.Form.Get("nonce"),
+	}
+	state := r.Form.Get("state")
+
+	c, err := s.auth.CheckAuthorize(req)
+	switch err {
+	case monban.ErrInvalidClient:
+		return renderAuthorize(w, http.StatusBadRequest, &authorizePage{Error: "Unknown client."})
+	case monban.ErrInvalidRedirectURI:
+		return renderAuthorize(w, http.StatusBadRequest, &authorizePage{Error: "Invalid redirect URI."})
+	case monban.ErrInvalidChallenge:
+		return redirectError(w, r, req.RedirectURI, state, invalidRequestType)
+	case nil:
+	default:
+		return E(err, "authorization failed", http.StatusInternalServerError)
+	}
+	// From now on errors are reported to the client by redirecting.
+	if r.Form.Get("response_type") != "code" {
+		return redirectError(w, r, req.RedirectURI, state, unsupportedResponseTypeType)
+	}
+
+	page := &authorizePage{Client: c, Request: req, State: state}
+	if r.Method == "GET" {
+		return renderAuthorize(w, http.StatusOK, page)
+	}
+	if r.PostForm.Get("action") != "allow" {
+		return redirectError(w, r, req.RedirectURI, state, accessDeniedType)
+	}
+	username := r.PostForm.Get("username")
+	code, err := s.auth.Authorize(username, r.PostForm.Get("password"), req)
+	if err != nil {
+		if err == monban.ErrInvalidScope {
+			return redirectError(w, r, req.RedirectURI, state, invalidScopeType)
+		}
+		if err == monban.ErrWrongCredentials {
+			page.Username = username
+			page.Error = "Wrong username or password."
+			return renderAuthorize(w, http.StatusUnauthorized, page)
+		}
+		return E(err, "authorization failed", http.StatusInternalServerError)
+	}
+	return redirect(w, r, req.RedirectURI, url.Values{"code": {code}}, state)
+}
+
+type tokenResp struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// handleToken implements the token endpoint of OAuth 2.0. Clients exchange
+// authorization codes, device codes and refresh tokens for new tokens or ask
+// for tokens for themselves with their client credentials.
+func (s *server) handleToken(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	c, err := s.authenticateClient(w, r)
+	if err != nil {
+		return err
+	}
+	treq := tokenRequest(r, nil)
+	treq.ClientID = c.ID
+
+	var grant *monban.Grant
+	switch r.PostFormValue("grant_type") {
+	case "authorization_code":
+		grant, err = s.auth.ExchangeCode(c,
+			r.PostFormValue("code"),
+			r.PostFormValue("redirect_uri"),
+			r.PostFormValue("code_verifier"),
+			treq,
+		)
+	case "refresh_token":
+		treq.Scope = strings.Fields(r.PostFormValue("scope"))
+		grant, err = s.auth.Refresh(r.PostFormValue("refresh_token"), treq)
+	case "client_credentials":
+		grant, err = s.auth.ClientCredentials(c, r.PostFormValue("scope"))
+	case deviceCodeGrantType:
+		grant, err = s.auth.PollDevice(c, r.PostFormValue("device_code"), treq)
+	default:
+		return &Error{Message: "unsupported grant type", Code: http.StatusBadRequest, Type: unsupportedGrantType}
+	}
+	if err != nil {
+		if _, ok := err.(*jwt.Error); ok || err == monban.ErrInvalidGrant || err == monban.ErrInvalidToken {
+			return &Error{err: err, Message: "invalid grant", Code: http.StatusBadRequest, Type: invalidGrantType}
+		}
+		if err == monban.ErrUnauthorizedClient {
+			return &Error{err: err, Message: "client not allowed to use grant", Code: http.StatusBadRequest, Type: unauthorizedClientType}
+		}
+		if err == monban.ErrInvalidScope {
+			return &Error{err: err, Message: "scope not allowed", Code: http.StatusBadRequest, Type: invalidScopeType}
+		}
+		if derr := deviceError(err); derr != nil {
+			return derr
+		}
+		return E(err, "token request failed", http.StatusInternalServerError)
+	}
+	return writeToken(w, grant)
+}
+
+// writeToken writes a successful token response of OAuth 2.0.
+func writeToken(w http.ResponseWriter, grant *monban.Grant) error {
+	resp := &tokenResp{
+		AccessToken:  grant.Access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(grant.ExpiresIn.Seconds()),
+		RefreshToken: grant.Refresh,
+		IDToken:      grant.IDToken,
+		Scope:        strings.Join(grant.Scope, " "),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "token response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+package rest_test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/monbantest"
+	"github.com/kusubooru/monban/rest"
+)
+
+// s256 returns the S256 PKCE code challenge of verifier.
+func s256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestLoginPages_headers(t *testing.T) {
+	auth, keys, teardown := setup(t)
+	defer teardown()
+	srv := rest.NewServer(auth, keys, "monban")
+	defer srv.Close()
+
+	c := &monban.Client{Name: "wiki", RedirectURIs: []string{"https://wiki.example.com/cb"}}
+	if _, err := auth.RegisterClient(c); err != nil {
+		t.Fatal("RegisterClient failed:", err)
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ID},
+		"code_challenge":        {s256("verifier")},
+		"code_challenge_method": {"S256"},
+	}
+	for _, path := range []string{"/authorize?" + q.Encode(), "/device"} {
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("GET %s status = %d, want %d (body: %s)", path, got, want, w.Body)
+		}
+		headers := map[string]string{
+			"X-Frame-Options":         "DENY",
+			"Content-Security-Policy": "frame-ancestors 'none'",
+			"Cache-Control":           "no-store",
+		}
+		for k, want := range headers {
+			if got := w.Header().Get(k); got != want {
+				t.Errorf("GET %s header %s = %q, want %q", path, k, got, want)
+			}
+		}
+	}
+}
+
+func TestToken_authorizationCode(t *testing.T) {
+	auth, keys, teardown := setup(t)
+	defer teardown()
+	srv := rest.NewServer(auth, keys, "monban")
+	defer srv.Close()
+
+	const redirectURI = "https://wiki.example.com/cb"
+	wiki := &monban.Client{Name: "wiki", RedirectURIs: []string{redirectURI}, Scopes: []string{"posts:read"}}
+	wikiSecret, err := auth.RegisterClient(wiki)
+	if err != nil {
+		t.Fatal("RegisterClient failed:", err)
+	}
+	other := &monban.Client{Name: "other", RedirectURIs: []string{redirectURI}}
+	otherSecret, err := auth.RegisterClient(other)
+	if err != nil {
+		t.Fatal("RegisterClient failed:", err)
+	}
+
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	code, err := auth.Authorize("alice", monbantest.Password, &monban.AuthorizeRequest{
+		ClientID:            wiki.ID,
+		RedirectURI:         redirectURI,
+		CodeChallenge:       s256(verifier),
+		CodeChallengeMethod: "S256",
+	})
+	if err != nil {
+		t.Fatal("Authorize failed:", err)
+	}
+
+	// The failed exchanges must not use up the code so that the valid one
+	// still succeeds. The code can only be exchanged once.
+	tests := []struct {
+		name        string
+		client      *monban.Client
+		secret      string
+		redirectURI string
+		verifier    string
+		want        int
+	}{
+		{"wrong verifier", wiki, wikiSecret, redirectURI, "wrong", http.StatusBadRequest},
+		{"wrong client", other, otherSecret, redirectURI, verifier, http.StatusBadRequest},
+		{"wrong redirect_uri", wiki, wikiSecret, "https://evil.example.com/cb", verifier, http.StatusBadRequest},
+		{"valid", wiki, wikiSecret, redirectURI, verifier, http.StatusOK},
+		{"reuse", wiki, wikiSecret, redirectURI, verifier, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		form := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {tt.redirectURI},
+			"code_verifier": {tt.verifier},
+		}
+		r := httptest.NewRequest("POST", "/token", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(tt.client.ID, tt.secret)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if got := w.Code; got != tt.want {
+			t.Errorf("%s: POST /token status = %d, want %d (body: %s)", tt.name, got, tt.want, w.Body)
+		}
+		if tt.want != http.StatusOK && !strings.Contains(w.Body.String(), "invalid_grant") {
+			t.Errorf("%s: POST /token body = %s, want invalid_grant error", tt.name, w.Body)
+		}
+	}
+}
+
+// clientGrant returns a newly registered client and the tokens it gets for
+// alice through the authorization code grant with the given scope and nonce.
+func clientGrant(t *testing.T, auth monban.AuthService, scope, nonce string) (*monban.Client, *monban.Grant) {
+	const redirectURI = "https://wiki.example.com/cb"
+	c := &monban.Client{Name: "wiki", RedirectURIs: []string{redirectURI}, Scopes: []string{"posts:read"}}
+	if _, err := auth.RegisterClient(c); err != nil {
+		t.Fatal("RegisterClient failed:", err)
+	}
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	code, err := auth.Authorize("alice", monbantest.Password, &monban.AuthorizeRequest{
+		ClientID:            c.ID,
+		RedirectURI:         redirectURI,
+		CodeChallenge:       s256(verifier),
+		CodeChallengeMethod: "S256",
+		Scope:               scope,
+		Nonce:               nonce,
+	})
+	if err != nil {
+		t.Fatal("Authorize failed:", err)
+	}
+	g, err := auth.ExchangeCode(c, code, redirectURI, verifier, &monban.TokenRequest{ClientID: c.ID})
+	if err != nil {
+		t.Fatal("ExchangeCode failed:", err)
+	}
+	return c, g
+}
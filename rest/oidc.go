@@ -0,0 +1,110 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+type discoveryResp struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	IntrospectionEndpoint             string   `json:"introspection_endpoint"`
+	RevocationEndpoint                string   `json:"revocation_endpoint"`
+	DeviceAuthorizationEndpoint       string   `json:"device_authorization_endpoint"`
+	ScopesSupported                   []string `json:"scopes_supported"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	SubjectTypesSupported             []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported  []string `json:"id_token_signing_alg_values_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	ClaimsSupported                   []string `json:"claims_supported"`
+}
+
+// handleDiscovery serves the OpenID Connect discovery document. The endpoints
+// are relative to the issuer which must be the URL Monban is served at.
+func (s *server) handleDiscovery(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	base := strings.TrimSuffix(s.issuer, "/")
+	resp := &discoveryResp{
+		Issuer:                            s.issuer,
+		AuthorizationEndpoint:             base + "/authorize",
+		TokenEndpoint:                     base + "/token",
+		UserinfoEndpoint:                  base + "/userinfo",
+		JWKSURI:                           base + "/.well-known/jwks.json",
+		IntrospectionEndpoint:             base + "/introspect",
+		RevocationEndpoint:                base + "/revoke",
+		DeviceAuthorizationEndpoint:       base + "/device/code",
+		ScopesSupported:                   []string{"openid", "profile", scopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "refresh_token", "client_credentials", deviceCodeGrantType},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  []string{s.keys.Current().Alg},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported: []string{
+			"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "at_hash",
+			"name", "preferred_username", "email",
+		},
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "discovery response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// scopeEmail is the OpenID Connect scope that grants access to the email of
+// the user.
+const scopeEmail = "email"
+
+type userInfoResp struct {
+	Subject           string `json:"sub"`
+	Name              string `json:"name"`
+	PreferredUsername string `json:"preferred_username"`
+	Email             string `json:"email,omitempty"`
+	Class             string `json:"class"`
+	Admin             bool   `json:"admin"`
+}
+
+// handleUserInfo implements the UserInfo endpoint of OpenID Connect. It
+// describes the user the access token was issued to. The email is only
+// included if the access token has the email scope.
+func (s *server) handleUserInfo(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" && r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	tok, err := s.authenticate(r)
+	if err != nil {
+		return err
+	}
+	u, err := s.auth.UserInfo(tok)
+	if err != nil {
+		if terr := tokenError(err); terr != nil {
+			return terr
+		}
+		return E(err, "getting user info failed", http.StatusInternalServerError)
+	}
+	resp := &userInfoResp{
+		Subject:           strconv.FormatInt(u.ID, 10),
+		Name:              u.Name,
+		PreferredUsername: u.Name,
+		Class:             u.Class,
+		Admin:             u.Admin,
+	}
+	if tok.HasScope(scopeEmail) {
+		resp.Email = u.Email
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "user info response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package rest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kusubooru/monban/jwt"
+	"github.com/kusubooru/monban/rest"
+)
+
+func TestIDToken(t *testing.T) {
+	auth, keys, teardown := setup(t)
+	defer teardown()
+
+	c, g := clientGrant(t, auth, "openid", "n-0S6_WzA2Mj")
+	if g.IDToken == "" {
+		t.Fatal("ExchangeCode with openid scope returned no ID token")
+	}
+	tok, valid, err := keys.Decode(g.IDToken)
+	if err != nil || !valid {
+		t.Fatalf("decoding ID token failed: valid %t, err %v", valid, err)
+	}
+	if tok.Type != jwt.TypeID || !tok.HasAudience(c.ID) {
+		t.Errorf("ID token has type %q and audience %q, want type %q for client %q", tok.Type, tok.Audience, jwt.TypeID, c.ID)
+	}
+	if got, want := tok.Claims["nonce"], "n-0S6_WzA2Mj"; got != want {
+		t.Errorf("ID token nonce = %v, want %q", got, want)
+	}
+	atHash, err := jwt.AccessTokenHash(jwt.HS256, g.Access)
+	if err != nil {
+		t.Fatal("AccessTokenHash failed:", err)
+	}
+	if got := tok.Claims["at_hash"]; got != atHash {
+		t.Errorf("ID token at_hash = %v, want %q", got, atHash)
+	}
+
+	// The nonce is left out when the client sends none.
+	_, g = clientGrant(t, auth, "openid", "")
+	tok, _, err = keys.Decode(g.IDToken)
+	if err != nil {
+		t.Fatal("decoding ID token failed:", err)
+	}
+	if nonce, ok := tok.Claims["nonce"]; ok {
+		t.Errorf("ID token without requested nonce has nonce %v", nonce)
+	}
+
+	// No ID token is issued without the openid scope.
+	if _, g = clientGrant(t, auth, "", ""); g.IDToken != "" {
+		t.Errorf("ExchangeCode without openid scope returned ID token %q", g.IDToken)
+	}
+}
+
+func TestUserInfo_email(t *testing.T) {
+	auth, keys, teardown := setup(t)
+	defer teardown()
+	srv := rest.NewServer(auth, keys, "monban")
+	defer srv.Close()
+
+	tests := []struct {
+		scope string
+		want  string
+	}{
+		{"openid email", "alice@example.com"},
+		{"openid", ""},
+	}
+	for _, tt := range tests {
+		_, g := clientGrant(t, auth, tt.scope, "")
+		r := httptest.NewRequest("GET", "/userinfo", nil)
+		r.Header.Set("Authorization", "Bearer "+g.Access)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("scope %q: GET /userinfo status = %d, want %d (body: %s)", tt.scope, got, want, w.Body)
+		}
+		var resp map[string]interface{}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("scope %q: decoding GET /userinfo response failed: %v", tt.scope, err)
+		}
+		email, _ := resp["email"].(string)
+		if email != tt.want {
+			t.Errorf("scope %q: GET /userinfo email = %q, want %q", tt.scope, email, tt.want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package rest
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/kusubooru/monban/monban"
+)
+
+type passwordReq struct {
+	OldPassword string   `json:"old_password"`
+	NewPassword string   `json:"new_password"`
+	Audience    []string `json:"audience"`
+	Scope       []string `json:"scope"`
+}
+
+// handlePassword changes the password of the user of the access token. All of
+// the user's sessions are ended and new tokens are returned like on login.
+// Personal access tokens and the tokens issued to clients cannot be used to
+// change passwords.
+func (s *server) handlePassword(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	tok, err := s.authenticateFirstParty(r)
+	if err != nil {
+		return err
+	}
+	if monban.IsPersonalToken(tok) {
+		return E(nil, "personal tokens cannot change passwords", http.StatusForbidden)
+	}
+	userID, err := tokenUserID(tok)
+	if err != nil {
+		return err
+	}
+	req := new(passwordReq)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return E(err, "expecting old and new password", http.StatusBadRequest)
+	}
+
+	treq := tokenRequest(r, req.Audience)
+	treq.Scope = req.Scope
+	grant, err := s.auth.ChangePassword(userID, req.OldPassword, req.NewPassword, treq)
+	if err != nil {
+		switch err {
+		case monban.ErrWrongCredentials:
+			return E(err, "wrong password", http.StatusUnauthorized)
+		case monban.ErrInvalidPassword:
+			return E(err, "new password must be at least 8 characters", http.StatusBadRequest)
+		case monban.ErrInvalidAudience:
+			return E(err, "audience not allowed", http.StatusBadRequest)
+		case monban.ErrInvalidScope:
+			return &Error{err: err, Message: "scope not allowed", Code: http.StatusBadRequest, Type: invalidScopeType}
+		}
+		return E(err, "changing password failed", http.StatusInternalServerError)
+	}
+	resp := &loginResp{AccessToken: grant.Access, RefreshToken: grant.Refresh}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "password response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+type passwordResetReq struct {
+	Email string `json:"email"`
+}
+
+// resetQueueSize is how many password reset requests can wait to be
+// processed.
+const resetQueueSize = 64
+
+// resetQueue processes password reset requests on a separate goroutine. The
+// request is only queued before responding, so the response takes the same
+// time whether there is an account to send the token to or not and does not
+// wait for the email to be sent.
+type resetQueue struct {
+	auth   monban.AuthService
+	emails chan string
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func newResetQueue(auth monban.AuthService) *resetQueue {
+	q := &resetQueue{
+		auth:   auth,
+		emails: make(chan string, resetQueueSize),
+		done:   make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *resetQueue) run() {
+	defer close(q.done)
+	for email := range q.emails {
+		if err := q.auth.RequestPasswordReset(email); err != nil {
+			log.Printf("password reset failed: %v", err)
+		}
+	}
+}
+
+// add queues a password reset request for email. It reports false if the
+// queue is full or closed.
+func (q *resetQueue) add(email string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	select {
+	case q.emails <- email:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops accepting requests and waits for the queued ones to be
+// processed.
+func (q *resetQueue) close() {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.emails)
+	}
+	q.mu.Unlock()
+	<-q.done
+}
+
+// handlePasswordReset emails a password reset token to the user with the
+// given email. It responds the same whether there is such a user or not.
+func (s *server) handlePasswordReset(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	req := new(passwordResetReq)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return E(err, "expecting email", http.StatusBadRequest)
+	}
+	if req.Email == "" {
+		return E(nil, "expecting email in request", http.StatusBadRequest)
+	}
+
+	if !s.auth.PasswordResetEnabled() {
+		return E(monban.ErrResetDisabled, "password reset is not enabled", http.StatusNotImplemented)
+	}
+	if !s.resets.add(req.Email) {
+		return E(nil, "too many password reset requests, try again later", http.StatusServiceUnavailable)
+	}
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+type passwordResetConfirmReq struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
+}
+
+// handlePasswordResetConfirm sets a new password with a password reset token
+// and ends all of the user's sessions.
+func (s *server) handlePasswordResetConfirm(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	req := new(passwordResetConfirmReq)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return E(err, "expecting token and new password", http.StatusBadRequest)
+	}
+
+	if err := s.auth.ResetPassword(req.Token, req.NewPassword); err != nil {
+		switch err {
+		case monban.ErrInvalidToken:
+			return &Error{err: err, Message: "invalid or expired reset token", Code: http.StatusBadRequest, Type: invalidTokenType}
+		case monban.ErrInvalidPassword:
+			return E(err, "new password must be at least 8 characters", http.StatusBadRequest)
+		}
+		return E(err, "password reset failed", http.StatusInternalServerError)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,152 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kusubooru/monban/monban"
+)
+
+type personalTokenReq struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresIn is the lifetime of the token in seconds. Tokens without one
+	// never expire.
+	ExpiresIn int64 `json:"expires_in"`
+}
+
+type personalTokenResp struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	LastUsed  *time.Time `json:"last_used,omitempty"`
+	Created   time.Time  `json:"created"`
+	// Token is only sent when the token is created.
+	Token string `json:"token,omitempty"`
+}
+
+func newPersonalTokenResp(pt *monban.PersonalToken) *personalTokenResp {
+	resp := &personalTokenResp{
+		ID:      pt.ID,
+		Name:    pt.Name,
+		Scopes:  pt.Scopes,
+		Created: pt.Created,
+	}
+	if resp.Scopes == nil {
+		resp.Scopes = []string{}
+	}
+	if !pt.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &pt.ExpiresAt
+	}
+	if !pt.LastUsed.IsZero() {
+		resp.LastUsed = &pt.LastUsed
+	}
+	return resp
+}
+
+// maxPersonalTokenName is the length of the longest name of a personal access
+// token.
+const maxPersonalTokenName = 64
+
+// handlePersonalTokens lists the personal access tokens of the user with GET
+// and creates a new one with POST. The token itself is only returned when it
+// is created. Personal access tokens cannot be used to create more of them and
+// clients cannot use the tokens issued to them to see or create any.
+func (s *server) handlePersonalTokens(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" && r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	tok, err := s.authenticateFirstParty(r)
+	if err != nil {
+		return err
+	}
+	userID, err := tokenUserID(tok)
+	if err != nil {
+		return err
+	}
+
+	if r.Method == "GET" {
+		tokens, err := s.auth.PersonalTokens(userID)
+		if err != nil {
+			return E(err, "listing personal tokens failed", http.StatusInternalServerError)
+		}
+		resp := make([]*personalTokenResp, len(tokens))
+		for i, pt := range tokens {
+			resp[i] = newPersonalTokenResp(pt)
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			return E(err, "personal tokens response encode failed", http.StatusInternalServerError)
+		}
+		return nil
+	}
+
+	if monban.IsPersonalToken(tok) {
+		return E(nil, "personal tokens cannot create personal tokens", http.StatusForbidden)
+	}
+	req := new(personalTokenReq)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return E(err, "expecting personal token name", http.StatusBadRequest)
+	}
+	if req.Name == "" || len(req.Name) > maxPersonalTokenName {
+		return E(nil, "expecting name of up to 64 characters in request", http.StatusBadRequest)
+	}
+	if !validNames(req.Scopes) {
+		return E(nil, "scopes cannot be empty or contain spaces", http.StatusBadRequest)
+	}
+	if req.ExpiresIn < 0 {
+		return E(nil, "expires_in cannot be negative", http.StatusBadRequest)
+	}
+	var expiresAt time.Time
+	if req.ExpiresIn != 0 {
+		expiresAt = time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+	}
+
+	pt, token, err := s.auth.CreatePersonalToken(userID, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		if err == monban.ErrInvalidScope {
+			return &Error{err: err, Message: "scope not allowed", Code: http.StatusBadRequest, Type: invalidScopeType}
+		}
+		if err == monban.ErrNotFound || err == monban.ErrWrongCredentials {
+			return &Error{err: err, Message: "user cannot create personal tokens", Code: http.StatusForbidden}
+		}
+		return E(err, "personal token creation failed", http.StatusInternalServerError)
+	}
+	resp := newPersonalTokenResp(pt)
+	resp.Token = token
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "personal token response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (s *server) handlePersonalToken(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "DELETE" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/tokens/")
+	if id == "" || strings.Contains(id, "/") {
+		return E(nil, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}
+	tok, err := s.authenticateFirstParty(r)
+	if err != nil {
+		return err
+	}
+	userID, err := tokenUserID(tok)
+	if err != nil {
+		return err
+	}
+
+	if err := s.auth.RevokePersonalToken(userID, id); err != nil {
+		if err == monban.ErrNotFound {
+			return E(err, "personal token not found", http.StatusNotFound)
+		}
+		return E(err, "revoking personal token failed", http.StatusInternalServerError)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
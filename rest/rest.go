@@ -3,10 +3,15 @@ package rest
 import (
 	"encoding/json"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/NYTimes/gziphandler"
+	"github.com/kusubooru/monban/jwt"
 	"github.com/kusubooru/monban/monban"
 )
 
@@ -34,14 +39,55 @@ type server struct {
 	handlers http.Handler // stack of wrapped http.Handlers
 	mux      *http.ServeMux
 	auth     monban.AuthService
+	keys     *jwt.KeySet
+	issuer   string
+	resets   *resetQueue
 }
 
-// NewServer initializes and returns a new HTTP server.
-func NewServer(auth monban.AuthService) http.Handler {
-	s := &server{mux: http.NewServeMux(), auth: auth}
+// Server is the HTTP server of the Monban API.
+type Server interface {
+	http.Handler
+	// Close waits for the queued password reset requests to be processed.
+	// It is meant to be called on shutdown after which password reset
+	// requests are rejected.
+	Close() error
+}
+
+// NewServer initializes and returns a new HTTP server. The public keys of the
+// key set are published so that other services can verify tokens. The issuer
+// is the URL Monban is served at and is used for OpenID Connect discovery.
+func NewServer(auth monban.AuthService, keys *jwt.KeySet, issuer string) Server {
+	s := &server{
+		mux:    http.NewServeMux(),
+		auth:   auth,
+		keys:   keys,
+		issuer: issuer,
+		resets: newResetQueue(auth),
+	}
 	s.handlers = gziphandler.GzipHandler(allowCORS(s.mux))
 	s.mux.Handle("/login", handler(s.handleLogin))
 	s.mux.Handle("/refresh", handler(s.handleRefresh))
+	s.mux.Handle("/logout", handler(s.handleLogout))
+	s.mux.Handle("/password", handler(s.handlePassword))
+	s.mux.Handle("/password/reset", handler(s.handlePasswordReset))
+	s.mux.Handle("/password/reset/confirm", handler(s.handlePasswordResetConfirm))
+	s.mux.Handle("/admin/revoke", handler(s.handleAdminRevoke))
+	s.mux.Handle("/admin/clients", handler(s.handleAdminClients))
+	s.mux.Handle("/admin/users", handler(s.handleAdminUsers))
+	s.mux.Handle("/admin/users/", handler(s.handleAdminUser))
+	s.mux.Handle("/sessions", handler(s.handleSessions))
+	s.mux.Handle("/sessions/", handler(s.handleSession))
+	s.mux.Handle("/tokens", handler(s.handlePersonalTokens))
+	s.mux.Handle("/tokens/", handler(s.handlePersonalToken))
+	s.mux.Handle("/introspect", handler(s.handleIntrospect))
+	s.mux.Handle("/revoke", handler(s.handleRevoke))
+	s.mux.Handle("/authorize", handler(s.handleAuthorize))
+	s.mux.Handle("/token", handler(s.handleToken))
+	s.mux.Handle("/device", handler(s.handleDevice))
+	s.mux.Handle("/device/code", handler(s.handleDeviceCode))
+	s.mux.Handle("/userinfo", handler(s.handleUserInfo))
+	s.mux.Handle("/.well-known/jwks.json", handler(s.handleJWKS))
+	s.mux.Handle("/.well-known/openid-configuration", handler(s.handleDiscovery))
 	return s
 }
 
@@ -61,9 +107,9 @@ func allowCORS(h http.Handler) http.Handler {
 }
 
 func preflightHandler(w http.ResponseWriter, r *http.Request) {
-	headers := []string{"Content-Type", "Accept"}
+	headers := []string{"Content-Type", "Accept", "Authorization"}
 	w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ","))
-	methods := []string{"GET", "HEAD", "POST", "PUT", "DELETE"}
+	methods := []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
 	w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ","))
 	return
 }
@@ -76,11 +122,106 @@ func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.handlers.ServeHTTP(w, r)
 }
 
+// Close satisfies the Server interface for a server.
+func (s *server) Close() error {
+	s.resets.close()
+	return nil
+}
+
+// authenticate returns the access token sent as a bearer token in the
+// Authorization header of the request.
+func (s *server) authenticate(r *http.Request) (*jwt.Token, error) {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		return nil, E(nil, "expecting bearer token in Authorization header", http.StatusUnauthorized)
+	}
+	tok, err := s.auth.Authenticate(strings.TrimPrefix(h, prefix))
+	if err != nil {
+		if terr := tokenError(err); terr != nil {
+			return nil, terr
+		}
+		return nil, E(err, "authentication failed", http.StatusInternalServerError)
+	}
+	return tok, nil
+}
+
+// authenticateFirstParty is like authenticate but rejects the access tokens
+// issued to clients. Clients act for the user within the scopes they were
+// granted and cannot manage the account of the user.
+func (s *server) authenticateFirstParty(r *http.Request) (*jwt.Token, error) {
+	tok, err := s.authenticate(r)
+	if err != nil {
+		return nil, err
+	}
+	if monban.IsClientToken(tok) {
+		return nil, E(nil, "tokens issued to clients cannot be used for this endpoint", http.StatusForbidden)
+	}
+	return tok, nil
+}
+
+// authenticateAdmin is like authenticateFirstParty but also requires the
+// access token to belong to an admin. Personal tokens are long lived and
+// cannot be used for admin endpoints even when their owner is an admin.
+func (s *server) authenticateAdmin(r *http.Request) (*jwt.Token, error) {
+	tok, err := s.authenticateFirstParty(r)
+	if err != nil {
+		return nil, err
+	}
+	if monban.IsPersonalToken(tok) {
+		return nil, E(nil, "personal tokens cannot be used for admin endpoints", http.StatusForbidden)
+	}
+	if !tok.Admin {
+		return nil, E(nil, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	}
+	return tok, nil
+}
+
+// authenticateClient returns the client that sent the request. Clients send
+// their credentials using HTTP Basic authentication or the client_id and
+// client_secret form parameters.
+func (s *server) authenticateClient(w http.ResponseWriter, r *http.Request) (*monban.Client, error) {
+	id, secret, ok := r.BasicAuth()
+	if !ok {
+		id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+	}
+	c, err := s.auth.AuthenticateClient(id, secret)
+	if err != nil {
+		if err == monban.ErrWrongCredentials {
+			w.Header().Set("WWW-Authenticate", `Basic realm="monban"`)
+			return nil, &Error{err: err, Message: "client authentication failed", Code: http.StatusUnauthorized, Type: invalidClientType}
+		}
+		return nil, E(err, "client authentication failed", http.StatusInternalServerError)
+	}
+	return c, nil
+}
+
+// tokenRequest describes the client that sent the request and the audiences
+// it asks tokens for.
+func tokenRequest(r *http.Request, audience []string) *monban.TokenRequest {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	return &monban.TokenRequest{IP: ip, UserAgent: r.UserAgent(), Audience: audience}
+}
+
+// tokenUserID returns the ID of the user an access token was issued to.
+func tokenUserID(tok *jwt.Token) (int64, error) {
+	id, err := strconv.ParseInt(tok.Subject, 10, 64)
+	if err != nil {
+		return 0, E(err, "invalid token subject", http.StatusUnauthorized)
+	}
+	return id, nil
+}
+
 // TODO(jin): Should username and password be sent via headers?
 
 type loginReq struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	Audience []string `json:"audience"`
+	Scope    []string `json:"scope"`
 }
 
 type loginResp struct {
@@ -96,11 +237,19 @@ func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) error {
 	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
 		return E(err, "expecting user credentials", http.StatusBadRequest)
 	}
-	tok, err := s.auth.Login(req.Username, req.Password)
+	treq := tokenRequest(r, req.Audience)
+	treq.Scope = req.Scope
+	tok, err := s.auth.Login(req.Username, req.Password, treq)
 	if err != nil {
 		if err == monban.ErrWrongCredentials {
 			return E(err, "wrong username or password", http.StatusUnauthorized)
 		}
+		if err == monban.ErrInvalidAudience {
+			return E(err, "audience not allowed", http.StatusBadRequest)
+		}
+		if err == monban.ErrInvalidScope {
+			return &Error{err: err, Message: "scope not allowed", Code: http.StatusBadRequest, Type: invalidScopeType}
+		}
 		return E(err, "login failed", http.StatusInternalServerError)
 	}
 	resp := &loginResp{AccessToken: tok.Access, RefreshToken: tok.Refresh}
@@ -111,7 +260,9 @@ func (s *server) handleLogin(w http.ResponseWriter, r *http.Request) error {
 }
 
 type refreshReq struct {
-	RefreshToken string `json:"refresh_token"`
+	RefreshToken string   `json:"refresh_token"`
+	Audience     []string `json:"audience"`
+	Scope        []string `json:"scope"`
 }
 
 type refreshResp struct {
@@ -131,10 +282,18 @@ func (s *server) handleRefresh(w http.ResponseWriter, r *http.Request) error {
 		return E(nil, "expecting refresh_token in request", http.StatusBadRequest)
 	}
 
-	tok, err := s.auth.Refresh(req.RefreshToken)
+	treq := tokenRequest(r, req.Audience)
+	treq.Scope = req.Scope
+	tok, err := s.auth.Refresh(req.RefreshToken, treq)
 	if err != nil {
-		if err == monban.ErrInvalidToken {
-			return E(err, "invalid token", http.StatusUnauthorized)
+		if terr := tokenError(err); terr != nil {
+			return terr
+		}
+		if err == monban.ErrInvalidAudience {
+			return E(err, "audience not allowed", http.StatusBadRequest)
+		}
+		if err == monban.ErrInvalidScope {
+			return &Error{err: err, Message: "scope not allowed", Code: http.StatusBadRequest, Type: invalidScopeType}
 		}
 		return E(err, "refresh failed", http.StatusInternalServerError)
 	}
@@ -144,3 +303,290 @@ func (s *server) handleRefresh(w http.ResponseWriter, r *http.Request) error {
 	}
 	return nil
 }
+
+type logoutReq struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+func (s *server) handleLogout(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	req := new(logoutReq)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return E(err, "expecting refresh token", http.StatusBadRequest)
+	}
+	if req.RefreshToken == "" {
+		return E(nil, "expecting refresh_token in request", http.StatusBadRequest)
+	}
+
+	if err := s.auth.Logout(req.RefreshToken); err != nil {
+		if terr := tokenError(err); terr != nil {
+			return terr
+		}
+		return E(err, "logout failed", http.StatusInternalServerError)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type adminRevokeReq struct {
+	UserID int64 `json:"user_id"`
+}
+
+func (s *server) handleAdminRevoke(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	if _, err := s.authenticateAdmin(r); err != nil {
+		return err
+	}
+	req := new(adminRevokeReq)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return E(err, "expecting user ID", http.StatusBadRequest)
+	}
+	if req.UserID == 0 {
+		return E(nil, "expecting user_id in request", http.StatusBadRequest)
+	}
+
+	if err := s.auth.RevokeAll(req.UserID); err != nil {
+		return E(err, "revoke failed", http.StatusInternalServerError)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type sessionResp struct {
+	ID          string    `json:"id"`
+	Created     time.Time `json:"created"`
+	LastRefresh time.Time `json:"last_refresh"`
+	IP          string    `json:"ip"`
+	UserAgent   string    `json:"user_agent"`
+}
+
+func (s *server) handleSessions(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	tok, err := s.authenticateFirstParty(r)
+	if err != nil {
+		return err
+	}
+	userID, err := tokenUserID(tok)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.auth.Sessions(userID)
+	if err != nil {
+		return E(err, "listing sessions failed", http.StatusInternalServerError)
+	}
+	resp := make([]*sessionResp, len(sessions))
+	for i, sess := range sessions {
+		resp[i] = &sessionResp{
+			ID:          sess.ID,
+			Created:     sess.Created,
+			LastRefresh: sess.LastRefresh,
+			IP:          sess.IP,
+			UserAgent:   sess.UserAgent,
+		}
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "sessions response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (s *server) handleSession(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "DELETE" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	sessionID := strings.TrimPrefix(r.URL.Path, "/sessions/")
+	if sessionID == "" || strings.Contains(sessionID, "/") {
+		return E(nil, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}
+	tok, err := s.authenticateFirstParty(r)
+	if err != nil {
+		return err
+	}
+	userID, err := tokenUserID(tok)
+	if err != nil {
+		return err
+	}
+
+	if err := s.auth.RevokeSession(userID, sessionID); err != nil {
+		if err == monban.ErrNotFound {
+			return E(err, "session not found", http.StatusNotFound)
+		}
+		return E(err, "revoking session failed", http.StatusInternalServerError)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type adminClientReq struct {
+	Name         string   `json:"name"`
+	Public       bool     `json:"public"`
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	Audience     []string `json:"audience"`
+}
+
+type adminClientResp struct {
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+func (s *server) handleAdminClients(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	if _, err := s.authenticateAdmin(r); err != nil {
+		return err
+	}
+	req := new(adminClientReq)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return E(err, "expecting client name", http.StatusBadRequest)
+	}
+	if req.Name == "" {
+		return E(nil, "expecting name in request", http.StatusBadRequest)
+	}
+
+	for _, uri := range req.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || !u.IsAbs() || u.Fragment != "" || strings.ContainsAny(uri, " ") {
+			return E(err, "invalid redirect URI", http.StatusBadRequest)
+		}
+	}
+
+	if !validNames(req.Scopes) || !validNames(req.Audience) {
+		return E(nil, "scopes and audiences cannot be empty or contain spaces", http.StatusBadRequest)
+	}
+
+	c := &monban.Client{
+		Name:         req.Name,
+		Public:       req.Public,
+		RedirectURIs: req.RedirectURIs,
+		Scopes:       req.Scopes,
+		Audience:     req.Audience,
+	}
+	secret, err := s.auth.RegisterClient(c)
+	if err != nil {
+		return E(err, "client registration failed", http.StatusInternalServerError)
+	}
+	resp := &adminClientResp{ClientID: c.ID, ClientSecret: secret}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "client response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// validNames reports whether none of the names is empty or contains spaces.
+func validNames(names []string) bool {
+	for _, n := range names {
+		if n == "" || strings.ContainsAny(n, " ") {
+			return false
+		}
+	}
+	return true
+}
+
+type introspectResp struct {
+	Active    bool     `json:"active"`
+	TokenType string   `json:"token_type,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Class     string   `json:"class,omitempty"`
+	Admin     bool     `json:"admin,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+}
+
+// handleIntrospect implements token introspection as defined by RFC 7662. The
+// token is sent as the token form parameter. The token_type_hint parameter is
+// accepted but not needed as the type is found from the token itself.
+func (s *server) handleIntrospect(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	c, err := s.authenticateClient(w, r)
+	if err != nil {
+		return err
+	}
+	// Public clients cannot be trusted to keep the results to themselves.
+	if c.Public {
+		return &Error{Message: "public clients cannot introspect tokens", Code: http.StatusForbidden, Type: unauthorizedClientType}
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		return &Error{Message: "expecting token in request", Code: http.StatusBadRequest, Type: invalidRequestType}
+	}
+
+	in, err := s.auth.Introspect(token)
+	if err != nil {
+		return E(err, "introspection failed", http.StatusInternalServerError)
+	}
+	resp := &introspectResp{
+		Active:    in.Active,
+		TokenType: in.TokenType,
+		Scope:     in.Scope,
+		ClientID:  in.ClientID,
+		Username:  in.Username,
+		Class:     in.Class,
+		Admin:     in.Admin,
+		ExpiresAt: in.ExpiresAt,
+		IssuedAt:  in.IssuedAt,
+		Subject:   in.Subject,
+		Audience:  in.Audience,
+		Issuer:    in.Issuer,
+		ID:        in.ID,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "introspection response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// handleRevoke implements token revocation as defined by RFC 7009. The token
+// is sent as the token form parameter. As with introspection, the
+// token_type_hint parameter is accepted but not needed. Invalid tokens and
+// tokens issued to other clients are not reported so that clients cannot
+// learn about them. The tokens of /login are not issued to any client and are
+// ignored too; /logout is the only way to revoke them.
+func (s *server) handleRevoke(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	c, err := s.authenticateClient(w, r)
+	if err != nil {
+		return err
+	}
+	token := r.PostFormValue("token")
+	if token == "" {
+		return &Error{Message: "expecting token in request", Code: http.StatusBadRequest, Type: invalidRequestType}
+	}
+
+	if err := s.auth.Revoke(token, c.ID); err != nil {
+		return E(err, "revocation failed", http.StatusInternalServerError)
+	}
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+func (s *server) handleJWKS(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(s.keys.JWKS()); err != nil {
+		return E(err, "jwks response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package rest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/monban/jwt"
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/monbantest"
+	"github.com/kusubooru/monban/rest"
+)
+
+// setup returns an AuthService from monbantest.Setup that issues access tokens
+// for booru along with its keys.
+func setup(t *testing.T) (monban.AuthService, *jwt.KeySet, func()) {
+	keys := jwt.NewHMACKeySet([]byte("secret"))
+	auth, _, teardown := monbantest.Setup(t, monban.Config{Audiences: []string{"booru"}, Keys: keys})
+	return auth, keys, teardown
+}
+
+func TestIntrospect_tokenType(t *testing.T) {
+	auth, keys, teardown := setup(t)
+	defer teardown()
+	srv := rest.NewServer(auth, keys, "monban")
+	defer srv.Close()
+
+	c := &monban.Client{Name: "booru"}
+	secret, err := auth.RegisterClient(c)
+	if err != nil {
+		t.Fatal("RegisterClient failed:", err)
+	}
+	g, err := auth.Login("alice", monbantest.Password, nil)
+	if err != nil {
+		t.Fatal("Login failed:", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  string
+	}{
+		{"access", g.Access, monban.TokenTypeAccess},
+		{"refresh", g.Refresh, monban.TokenTypeRefresh},
+		{"invalid", "invalid", ""},
+	}
+	for _, tt := range tests {
+		form := url.Values{"token": {tt.token}}
+		r := httptest.NewRequest("POST", "/introspect", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(c.ID, secret)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		if got, want := w.Code, http.StatusOK; got != want {
+			t.Fatalf("%s: POST /introspect status = %d, want %d (body: %s)", tt.name, got, want, w.Body)
+		}
+		var resp struct {
+			Active    bool   `json:"active"`
+			TokenType string `json:"token_type"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("%s: decoding POST /introspect response failed: %v", tt.name, err)
+		}
+		if got, want := resp.Active, tt.want != ""; got != want {
+			t.Errorf("%s: POST /introspect active = %t, want %t", tt.name, got, want)
+		}
+		if resp.TokenType != tt.want {
+			t.Errorf("%s: POST /introspect token_type = %q, want %q", tt.name, resp.TokenType, tt.want)
+		}
+	}
+}
+
+func TestRevoke_firstPartyToken(t *testing.T) {
+	auth, keys, teardown := setup(t)
+	defer teardown()
+	srv := rest.NewServer(auth, keys, "monban")
+	defer srv.Close()
+
+	c := &monban.Client{Name: "booru"}
+	secret, err := auth.RegisterClient(c)
+	if err != nil {
+		t.Fatal("RegisterClient failed:", err)
+	}
+	g, err := auth.Login("alice", monbantest.Password, nil)
+	if err != nil {
+		t.Fatal("Login failed:", err)
+	}
+
+	// Clients cannot revoke the tokens of /login.
+	form := url.Values{"token": {g.Refresh}}
+	r := httptest.NewRequest("POST", "/revoke", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(c.ID, secret)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("POST /revoke status = %d, want %d (body: %s)", got, want, w.Body)
+	}
+	in, err := auth.Introspect(g.Refresh)
+	if err != nil {
+		t.Fatal("Introspect failed:", err)
+	}
+	if !in.Active {
+		t.Fatal("refresh token of /login is not active after POST /revoke")
+	}
+
+	r = httptest.NewRequest("POST", "/logout", strings.NewReader(`{"refresh_token": "`+g.Refresh+`"}`))
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	if got, want := w.Code, http.StatusNoContent; got != want {
+		t.Fatalf("POST /logout status = %d, want %d (body: %s)", got, want, w.Body)
+	}
+	in, err = auth.Introspect(g.Refresh)
+	if err != nil {
+		t.Fatal("Introspect failed:", err)
+	}
+	if in.Active {
+		t.Error("refresh token is active after POST /logout")
+	}
+}
+
+func TestClientToken_firstPartyEndpoints(t *testing.T) {
+	keys := jwt.NewHMACKeySet([]byte("secret"))
+	auth, u, teardown := monbantest.Setup(t, monban.Config{Keys: keys})
+	defer teardown()
+	srv := rest.NewServer(auth, keys, "monban")
+	defer srv.Close()
+
+	u.Admin = true
+	if err := auth.UpdateUser(u, ""); err != nil {
+		t.Fatal("UpdateUser failed:", err)
+	}
+	login, err := auth.Login("alice", monbantest.Password, nil)
+	if err != nil {
+		t.Fatal("Login failed:", err)
+	}
+	_, client := clientGrant(t, auth, "", "")
+
+	tests := []struct {
+		method string
+		path   string
+		body   string
+		// login is the status for the token of /login. The token of a
+		// client is always forbidden.
+		login int
+	}{
+		{"GET", "/sessions", "", http.StatusOK},
+		{"DELETE", "/sessions/unknown", "", http.StatusNotFound},
+		{"POST", "/password", `{"old_password": "wrong", "new_password": "new password"}`, http.StatusUnauthorized},
+		{"GET", "/admin/users", "", http.StatusOK},
+		{"POST", "/admin/clients", `{"name": "other"}`, http.StatusCreated},
+		{"POST", "/admin/revoke", `{"user_id": 0}`, http.StatusBadRequest},
+		{"GET", "/tokens", "", http.StatusOK},
+		{"POST", "/tokens", `{"name": "ci", "scopes": ["posts:read"]}`, http.StatusCreated},
+		{"DELETE", "/tokens/unknown", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		for _, tok := range []struct {
+			name   string
+			access string
+			want   int
+		}{
+			{"login", login.Access, tt.login},
+			{"client", client.Access, http.StatusForbidden},
+		} {
+			r := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer "+tok.access)
+			w := httptest.NewRecorder()
+			srv.ServeHTTP(w, r)
+			if got := w.Code; got != tok.want {
+				t.Errorf("%s %s with %s token: status = %d, want %d (body: %s)", tt.method, tt.path, tok.name, got, tok.want, w.Body)
+			}
+		}
+	}
+}
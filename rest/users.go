@@ -0,0 +1,228 @@
+package rest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kusubooru/monban/monban"
+)
+
+type userResp struct {
+	ID      int64     `json:"id"`
+	Name    string    `json:"name"`
+	Email   string    `json:"email"`
+	Class   string    `json:"class"`
+	Admin   bool      `json:"admin"`
+	Created time.Time `json:"created"`
+	Joined  time.Time `json:"joined"`
+}
+
+func newUserResp(u *monban.User) *userResp {
+	return &userResp{
+		ID:      u.ID,
+		Name:    u.Name,
+		Email:   u.Email,
+		Class:   u.Class,
+		Admin:   u.Admin,
+		Created: u.Created,
+		Joined:  u.Joined,
+	}
+}
+
+// adminUserReq creates or updates a user. When updating, only the fields that
+// are sent are changed and the password is only changed if it is not empty.
+type adminUserReq struct {
+	Name     *string `json:"name"`
+	Password string  `json:"password"`
+	Email    *string `json:"email"`
+	Class    *string `json:"class"`
+	Admin    *bool   `json:"admin"`
+}
+
+// apply copies the fields of the request to user u and checks that they fit
+// in the users table.
+func (req *adminUserReq) apply(u *monban.User) error {
+	if req.Name != nil {
+		u.Name = *req.Name
+	}
+	if req.Email != nil {
+		u.Email = *req.Email
+	}
+	if req.Class != nil {
+		u.Class = *req.Class
+	}
+	if req.Admin != nil {
+		u.Admin = *req.Admin
+	}
+	if u.Name == "" || len(u.Name) > 32 || strings.ContainsAny(u.Name, " ") {
+		return E(nil, "expecting name of up to 32 characters without spaces", http.StatusBadRequest)
+	}
+	if u.Class == "" || len(u.Class) > 32 {
+		return E(nil, "expecting class of up to 32 characters", http.StatusBadRequest)
+	}
+	if len(u.Email) > 254 {
+		return E(nil, "email cannot be longer than 254 characters", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// handleAdminUsers lists users with GET and creates a user with POST. Users
+// are listed ordered by ID and can be filtered with the class, admin, name
+// (prefix) and email query parameters and paged with offset and limit.
+func (s *server) handleAdminUsers(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" && r.Method != "POST" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	if _, err := s.authenticateAdmin(r); err != nil {
+		return err
+	}
+	if r.Method == "POST" {
+		return s.createUser(w, r)
+	}
+
+	q := r.URL.Query()
+	var users []*monban.User
+	if email := q.Get("email"); email != "" {
+		u, err := s.auth.UserByEmail(email)
+		switch err {
+		case monban.ErrNotFound:
+		case nil:
+			users = append(users, u)
+		default:
+			return E(err, "getting user failed", http.StatusInternalServerError)
+		}
+	} else {
+		f, err := userFilter(q)
+		if err != nil {
+			return err
+		}
+		users, err = s.auth.ListUsers(f)
+		if err != nil {
+			return E(err, "listing users failed", http.StatusInternalServerError)
+		}
+	}
+	resp := make([]*userResp, len(users))
+	for i, u := range users {
+		resp[i] = newUserResp(u)
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		return E(err, "users response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// userFilter returns the filter of the query parameters of a user listing.
+func userFilter(q url.Values) (*monban.UserFilter, error) {
+	f := &monban.UserFilter{Class: q.Get("class"), NamePrefix: q.Get("name")}
+	if v := q.Get("admin"); v != "" {
+		admin, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, E(err, "admin must be true or false", http.StatusBadRequest)
+		}
+		f.Admin = &admin
+	}
+	for key, n := range map[string]*int{"offset": &f.Offset, "limit": &f.Limit} {
+		v := q.Get(key)
+		if v == "" {
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return nil, E(err, key+" must be a non-negative number", http.StatusBadRequest)
+		}
+		*n = i
+	}
+	return f, nil
+}
+
+func (s *server) createUser(w http.ResponseWriter, r *http.Request) error {
+	req := new(adminUserReq)
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return E(err, "expecting user", http.StatusBadRequest)
+	}
+	if req.Password == "" {
+		return E(nil, "expecting password in request", http.StatusBadRequest)
+	}
+	u := &monban.User{Pass: req.Password, Class: "user"}
+	if err := req.apply(u); err != nil {
+		return err
+	}
+	if err := s.auth.CreateUser(u); err != nil {
+		switch err {
+		case monban.ErrUserExists:
+			return E(err, "user already exists", http.StatusConflict)
+		case monban.ErrInvalidPassword:
+			return E(err, "password must be at least 8 characters", http.StatusBadRequest)
+		}
+		return E(err, "creating user failed", http.StatusInternalServerError)
+	}
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(newUserResp(u)); err != nil {
+		return E(err, "user response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+// handleAdminUser gets, updates or deletes the user with the ID of the path.
+// Changing the name, class or admin status of a user ends all of their
+// sessions. Changing their password or deleting them also deletes their
+// personal access tokens.
+func (s *server) handleAdminUser(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != "GET" && r.Method != "PATCH" && r.Method != "DELETE" {
+		return E(nil, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/admin/users/"), 10, 64)
+	if err != nil {
+		return E(err, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	}
+	if _, err := s.authenticateAdmin(r); err != nil {
+		return err
+	}
+
+	if r.Method == "DELETE" {
+		if err := s.auth.DeleteUser(id); err != nil {
+			if err == monban.ErrNotFound {
+				return E(err, "user not found", http.StatusNotFound)
+			}
+			return E(err, "deleting user failed", http.StatusInternalServerError)
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	u, err := s.auth.UserByID(id)
+	if err != nil {
+		if err == monban.ErrNotFound {
+			return E(err, "user not found", http.StatusNotFound)
+		}
+		return E(err, "getting user failed", http.StatusInternalServerError)
+	}
+	if r.Method == "PATCH" {
+		req := new(adminUserReq)
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return E(err, "expecting user", http.StatusBadRequest)
+		}
+		if err := req.apply(u); err != nil {
+			return err
+		}
+		if err := s.auth.UpdateUser(u, req.Password); err != nil {
+			switch err {
+			case monban.ErrNotFound:
+				return E(err, "user not found", http.StatusNotFound)
+			case monban.ErrUserExists:
+				return E(err, "user already exists", http.StatusConflict)
+			case monban.ErrInvalidPassword:
+				return E(err, "password must be at least 8 characters", http.StatusBadRequest)
+			}
+			return E(err, "updating user failed", http.StatusInternalServerError)
+		}
+	}
+	if err := json.NewEncoder(w).Encode(newUserResp(u)); err != nil {
+		return E(err, "user response encode failed", http.StatusInternalServerError)
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package rest_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/kusubooru/monban/monban"
+	"github.com/kusubooru/monban/monban/monbantest"
+	"github.com/kusubooru/monban/rest"
+)
+
+func TestAdminUser(t *testing.T) {
+	auth, keys, teardown := setup(t)
+	defer teardown()
+	srv := rest.NewServer(auth, keys, "monban")
+	defer srv.Close()
+
+	admin := &monban.User{Name: "root", Pass: monbantest.Password, Admin: true}
+	if err := auth.CreateUser(admin); err != nil {
+		t.Fatal("CreateUser failed:", err)
+	}
+	g, err := auth.Login("root", monbantest.Password, nil)
+	if err != nil {
+		t.Fatal("Login failed:", err)
+	}
+	bob := &monban.User{Name: "bob", Pass: monbantest.Password, Class: "user"}
+	if err := auth.CreateUser(bob); err != nil {
+		t.Fatal("CreateUser failed:", err)
+	}
+	path := "/admin/users/" + strconv.FormatInt(bob.ID, 10)
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+g.Access)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("PATCH", path, `{"class": "mod", "password": "short"}`)
+	if got, want := w.Code, http.StatusBadRequest; got != want {
+		t.Errorf("PATCH %s with short password status = %d, want %d (body: %s)", path, got, want, w.Body)
+	}
+	w = do("PATCH", path, `{"name": "root"}`)
+	if got, want := w.Code, http.StatusConflict; got != want {
+		t.Errorf("PATCH %s with taken name status = %d, want %d (body: %s)", path, got, want, w.Body)
+	}
+
+	// Only the fields that are sent are changed.
+	w = do("PATCH", path, `{"class": "mod", "password": "new password"}`)
+	if got, want := w.Code, http.StatusOK; got != want {
+		t.Fatalf("PATCH %s status = %d, want %d (body: %s)", path, got, want, w.Body)
+	}
+	var resp struct {
+		Name  string `json:"name"`
+		Class string `json:"class"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("decoding PATCH %s response failed: %v", path, err)
+	}
+	if resp.Name != "bob" || resp.Class != "mod" {
+		t.Errorf("PATCH %s returned name %q and class %q, want %q and %q", path, resp.Name, resp.Class, "bob", "mod")
+	}
+	if _, err := auth.Login("bob", "new password", nil); err != nil {
+		t.Errorf("Login with password of PATCH %s failed: %v", path, err)
+	}
+
+	if w := do("DELETE", path, ""); w.Code != http.StatusNoContent {
+		t.Errorf("DELETE %s status = %d, want %d (body: %s)", path, w.Code, http.StatusNoContent, w.Body)
+	}
+	if w := do("GET", path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("GET %s after DELETE status = %d, want %d (body: %s)", path, w.Code, http.StatusNotFound, w.Body)
+	}
+	if w := do("DELETE", path, ""); w.Code != http.StatusNotFound {
+		t.Errorf("DELETE %s after DELETE status = %d, want %d (body: %s)", path, w.Code, http.StatusNotFound, w.Body)
+	}
+}